@@ -1,13 +1,21 @@
 package lib
 
 import (
+	"errors"
+	"fmt"
+	"math"
 	"reflect"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
 
 	"github.com/google/cel-go/cel"
 	"github.com/google/cel-go/checker/decls"
 	"github.com/google/cel-go/common"
 	"github.com/google/cel-go/common/operators"
+	"github.com/google/cel-go/common/overloads"
 	"github.com/google/cel-go/common/types"
 	"github.com/google/cel-go/common/types/ref"
 	"github.com/google/cel-go/common/types/traits"
@@ -32,6 +40,41 @@ import (
 //     {"a":1, "b":2}.as(v, [v, v])           // return [{"a":1, "b":2}, {"a":1, "b":2}]
 //
 //
+// Field Paths
+//
+// Collate, drop and other path functions address values within nested maps
+// and lists using field paths. A path is a sequence of segments separated by
+// dots or introduced by a bracket. When a key segment is applied to a list,
+// it is applied to each element of the list:
+//
+//     a.b          the value of "b" in the value of "a"
+//     source\.ip   the value of the key "source.ip"; a backslash escapes
+//                  the next character
+//     a["b.c"]     the value of the key "b.c" in the value of "a"; keys may
+//                  be single or double quoted and use backslash escapes
+//     a[0]         the first element of the list "a"
+//     a[-1]        the last element of the list "a"
+//     a.*          all the children of the map or list "a", also a[*]
+//     a..b         the value of "b" in "a" or any of its descendants
+//
+// Note that backslashes must themselves be escaped in CEL string literals
+// unless a raw string is used, for example r"source\.ip".
+//
+//
+// Chunk
+//
+// Returns a list of consecutive sub-lists of the receiver with at most the
+// given number of elements. Only the last chunk may have fewer elements. The
+// returned list is lazily evaluated:
+//
+//     <list<dyn>>.chunk(<int>) -> <list<list<dyn>>>
+//
+// Examples:
+//
+//     [1, 2, 3, 4, 5].chunk(2)  // return [[1, 2], [3, 4], [5]]
+//     [].chunk(2)               // return []
+//
+//
 // Collate
 //
 // Returns a list of values obtained by traversing fields in the receiver with
@@ -63,6 +106,73 @@ import (
 //     v.collate("a.b")           // return [1, 2, 3]
 //     v.collate(["a.b", "b.b"])  // return [1, 2, 3, -1, -2, -3]
 //     v.collate(["a", "b.b"])    // return [{"b": 1 }, {"b": 2 }, {"b": 3 }, -1, -2, -3 ]
+//     v.collate("a[-1].b")       // return [3]
+//     v.collate("..c")           // return [10, 20, 30]
+//
+//
+// Count
+//
+// Returns the number of elements of a list that are not null:
+//
+//     <list<dyn>>.count() -> <int>
+//     count(<list<dyn>>) -> <int>
+//
+// Examples:
+//
+//     [1, null, 3].count()  // return 2
+//     count([])             // return 0
+//
+//
+// Difference
+//
+// Returns a list of the unique elements of the receiver that are not in the
+// parameter, in the order that they first appear in the receiver. Elements
+// are compared using CEL equality:
+//
+//     <list<dyn>>.difference(<list<dyn>>) -> <list<dyn>>
+//
+// Examples:
+//
+//     [1, 2, 3, 2].difference([2, 4])                  // return [1, 3]
+//     [{"id":1}, {"id":2}].difference([{"id":2}])      // return [{"id":1}]
+//
+//
+// Distinct
+//
+// Returns a list of the unique elements of the receiver in the order that
+// they first appear. Elements are compared using CEL equality, so lists and
+// maps are compared deeply:
+//
+//     <list<dyn>>.distinct() -> <list<dyn>>
+//
+// Examples:
+//
+//     [1, 2, 2, 3, 1].distinct()               // return [1, 2, 3]
+//     [{"a":1}, {"a":1}, {"a":2}].distinct()   // return [{"a":1}, {"a":2}]
+//
+//
+// Distinct By (Macro)
+//
+// The distinct_by and distinct_by_last macros return a list of the elements
+// of the receiver that are unique with respect to the key expression. The
+// distinct_by macro retains the first element for each key and distinct_by_last
+// retains the last element. The retained elements keep their relative order
+// in the receiver. Keys are compared using CEL equality:
+//
+//     <list<dyn>>.distinct_by(<ident>, <expr>) -> <list<dyn>>
+//     <list<dyn>>.distinct_by_last(<ident>, <expr>) -> <list<dyn>>
+//
+// Examples:
+//
+//     Given v:
+//     [
+//             {"id": 1, "v": "a"},
+//             {"id": 2, "v": "b"},
+//             {"id": 1, "v": "c"}
+//     ]
+//
+//     v.distinct_by(e, e.id)       // return [{"id": 1, "v": "a"}, {"id": 2, "v": "b"}]
+//     v.distinct_by_last(e, e.id)  // return [{"id": 2, "v": "b"}, {"id": 1, "v": "c"}]
 //
 //
 // Drop
@@ -94,6 +204,7 @@ import (
 //     v.drop("a.b")           // return {"a": [{}, {}, {}], "b": [{"b": -1, "c": 10}, {"b": -2, "c": 20}, {"b": -3, "c": 30}]}
 //     v.drop(["a.b", "b.b"])  // return {"a": [{}, {}, {}], "b": [{"c": 10}, {"c": 20}, {"c": 30}]}
 //     v.drop(["a", "b.b"])    // return {"b": [{"c": 10}, {"c": 20}, {"c": 30}]}
+//     v.drop(["a[0]", "..c"]) // return {"a": [{"b": 2}, {"b": 3}], "b": [{"b": -1}, {"b": -2}, {"b": -3}]}
 //
 //
 // Drop Empty
@@ -123,6 +234,52 @@ import (
 //     v.drop_empty()  // return {"b":[{"b":-1, "c":10}, {"b":-2, "c":20}, {"b":-3, "c":30}]}
 //
 //
+// Entries
+//
+// Returns a list of {"key": <key>, "value": <value>} maps for the fields of the
+// receiver in sorted key order:
+//
+//     <map<K,V>>.entries() -> <list<map<string,dyn>>>
+//
+// Examples:
+//
+//     {"b": 2, "a": 1}.entries()  // return [{"key": "a", "value": 1}, {"key": "b", "value": 2}]
+//
+//
+// Filter Entries (Macro)
+//
+// Returns a map containing only the fields of the receiver for which the
+// predicate is true. The first identifier is bound to the field key and the
+// second to the field value:
+//
+//     <map<K,V>>.filter_entries(<ident>, <ident>, <expr>) -> <map<K,V>>
+//
+// Examples:
+//
+//     {"a": 1, "b": 2, "c": 3}.filter_entries(k, v, v > 1 && k != "c")  // return {"b": 2}
+//
+//
+// First
+//
+// Returns the first element of a list, the first character of a string or
+// the first byte of bytes. If the receiver is empty, the optional parameter
+// is returned, or an error if it is not provided:
+//
+//     <list<V>>.first() -> <V>
+//     <list<V>>.first(<V>) -> <V>
+//     <string>.first() -> <string>
+//     <string>.first(<string>) -> <string>
+//     <bytes>.first() -> <bytes>
+//     <bytes>.first(<bytes>) -> <bytes>
+//
+// Examples:
+//
+//     [1, 2, 3].first()  // return 1
+//     [].first(0)        // return 0
+//     [].first()         // return error
+//     "hello".first()    // return "h"
+//
+//
 // Flatten
 //
 // Returns a list of non-list objects resulting from the depth-first
@@ -136,6 +293,224 @@ import (
 //     [[{"a":1,"b":[10, 11]}],[2,3],[[[4]],[5,6]]].flatten()  // return [{"a":1, "b":[10, 11]}, 2, 3, 4, 5, 6]
 //
 //
+// Flatten Keys
+//
+// Returns a single level map with a key for each non-empty map and list leaf
+// value in the receiver. The keys are constructed from the map keys on the
+// path to the value, joined by the separator, which defaults to ".", and
+// list indexes, which are bracketed and follow the key of the list. Backslash,
+// "[" and the separator are escaped with a backslash within keys. Empty maps
+// and lists are retained as values:
+//
+//     <map<string,dyn>>.flatten_keys() -> <map<string,dyn>>
+//     <map<string,dyn>>.flatten_keys(<string>) -> <map<string,dyn>>
+//
+// Examples:
+//
+//     {"a": {"b": 1, "c": [2, {"d": 3}]}}.flatten_keys()  // return {"a.b": 1, "a.c[0]": 2, "a.c[1].d": 3}
+//     {"a": {"b.c": 1}}.flatten_keys()                     // return {"a.b\\.c": 1}
+//     {"a": {"b": 1, "e": {}}}.flatten_keys("_")           // return {"a_b": 1, "a_e": {}}
+//
+//
+// From Entries
+//
+// Returns a map constructed from a list of {"key": <key>, "value": <value>}
+// maps or [<key>, <value>] pairs. If a key appears more than once, the last
+// value is used:
+//
+//     <list<dyn>>.from_entries() -> <map<dyn,dyn>>
+//
+// Examples:
+//
+//     [{"key": "a", "value": 1}, {"key": "b", "value": 2}].from_entries()  // return {"a": 1, "b": 2}
+//     [["a", 1], ["a", 2]].from_entries()                                  // return {"a": 2}
+//
+//
+// Get Path
+//
+// Returns the value at the given path in the receiver, or the default value
+// if the path does not exist. If no default is given, a missing path is an
+// error. The path must address a single value, so wildcards and recursive
+// descent are not allowed, and key segments are not applied to the elements
+// of lists:
+//
+//     <list<dyn>>.get_path(<string>) -> <dyn>
+//     <list<dyn>>.get_path(<string>, <dyn>) -> <dyn>
+//     <map<string,dyn>>.get_path(<string>) -> <dyn>
+//     <map<string,dyn>>.get_path(<string>, <dyn>) -> <dyn>
+//
+// Examples:
+//
+//     {"a": {"b": [1, 2, 3]}}.get_path("a.b[-1]")          // return 3
+//     {"a": {"b": [1, 2, 3]}}.get_path("a.c", "default")   // return "default"
+//     {"a": {"b": [1, 2, 3]}}.get_path("a.c")              // return error
+//
+//
+// Index By (Macro)
+//
+// Returns a map of the elements of the receiver keyed by the result of
+// evaluating the key expression with the identifier bound to the element.
+// An optional policy determines how elements with the same key are handled;
+// "first" keeps the first element, "last" keeps the last element, "error"
+// returns an error and "collect" makes each value a list of all the elements
+// with the key in the order they appear in the receiver. The default policy
+// is "last". Keys are compared using CEL equality and the first of a set of
+// equal keys is used in the result:
+//
+//     <list<V>>.index_by(<ident>, <expr>) -> <map<dyn,V>>
+//     <list<V>>.index_by(<ident>, <expr>, <string>) -> <map<dyn,dyn>>
+//
+// Examples:
+//
+//     [{"id": 1, "name": "a"}, {"id": 2, "name": "b"}].index_by(e, e.id)
+//                                           // return {1: {"id": 1, "name": "a"}, 2: {"id": 2, "name": "b"}}
+//     ["ab", "ac", "b"].index_by(e, e.size(), "first")    // return {1: "b", 2: "ab"}
+//     ["ab", "ac", "b"].index_by(e, e.size(), "collect")  // return {1: ["b"], 2: ["ab", "ac"]}
+//     ["ab", "ac", "b"].index_by(e, e.size(), "error")    // return error
+//
+//
+// Intersect
+//
+// Returns a list of the unique elements of the receiver that are also in
+// the parameter, in the order that they first appear in the receiver.
+// Elements are compared using CEL equality:
+//
+//     <list<dyn>>.intersect(<list<dyn>>) -> <list<dyn>>
+//
+// Examples:
+//
+//     [1, 2, 3, 2].intersect([2, 3, 4])               // return [2, 3]
+//     [{"id":1}, {"id":2}].intersect([{"id":2}])      // return [{"id":2}]
+//
+//
+// Join (Macro)
+//
+// Returns a list of records formed by joining the maps in the receiver with
+// the maps in the first parameter. The two identifiers are bound to elements
+// of the receiver and parameter respectively, and pairs of elements for which
+// the predicate is true are merged to form a record, with fields from the
+// parameter element replacing fields with the same name in the receiver
+// element. An optional kind selects an "inner" join, the default, a "left"
+// join which also includes receiver elements that match no parameter elements,
+// or a "full" join which additionally includes unmatched parameter elements
+// after the other records. Records are ordered by receiver element and then by
+// parameter element. If the predicate is an equality between an expression
+// that only refers to the receiver element and one that only refers to the
+// parameter element, the join is performed by key lookup, otherwise the
+// predicate is evaluated for every pair of elements:
+//
+//     <list<map<K,V>>>.join(<list<map<K,V>>>, <ident>, <ident>, <expr>) -> <list<map<K,V>>>
+//     <list<map<K,V>>>.join(<list<map<K,V>>>, <ident>, <ident>, <expr>, <string>) -> <list<map<K,V>>>
+//
+// Examples:
+//
+//     [{"user": "a", "group_id": 1}, {"user": "b", "group_id": 3}].join(
+//         [{"id": 1, "group": "admin"}, {"id": 2, "group": "staff"}], u, g, u.group_id == g.id)
+//                      // return [{"user": "a", "group_id": 1, "id": 1, "group": "admin"}]
+//
+//     [{"user": "a", "group_id": 1}, {"user": "b", "group_id": 3}].join(
+//         [{"id": 1, "group": "admin"}, {"id": 2, "group": "staff"}], u, g, u.group_id == g.id, "left")
+//                      // return [{"user": "a", "group_id": 1, "id": 1, "group": "admin"},
+//                      //         {"user": "b", "group_id": 3}]
+//
+//     [{"user": "a", "group_id": 1}, {"user": "b", "group_id": 3}].join(
+//         [{"id": 1, "group": "admin"}, {"id": 2, "group": "staff"}], u, g, u.group_id == g.id, "full")
+//                      // return [{"user": "a", "group_id": 1, "id": 1, "group": "admin"},
+//                      //         {"user": "b", "group_id": 3},
+//                      //         {"id": 2, "group": "staff"}]
+//
+//
+// Keep
+//
+// Returns the value of the receiver with only the objects at the given paths
+// retained. The structure of the retained objects is preserved. When a list
+// is traversed with a key, each element of the list is retained with only
+// the objects at the paths kept:
+//
+//     <list<dyn>>.keep(<string>) -> <list<dyn>>
+//     <list<dyn>>.keep(<list<string>>) -> <list<dyn>>
+//     <map<string,dyn>>.keep(<string>) -> <map<string,dyn>>
+//     <map<string,dyn>>.keep(<list<string>>) -> <map<string,dyn>>
+//
+// Examples:
+//
+//     Given v:
+//     {
+//             "a": [
+//                 {"b": 1},
+//                 {"b": 2},
+//                 {"b": 3}
+//             ],
+//             "b": [
+//                 {"b": -1, "c": 10},
+//                 {"b": -2, "c": 20},
+//                 {"b": -3, "c": 30}
+//             ]
+//     }
+//
+//     v.keep("a")             // return {"a": [{"b": 1}, {"b": 2}, {"b": 3}]}
+//     v.keep("b.c")           // return {"b": [{"c": 10}, {"c": 20}, {"c": 30}]}
+//     v.keep(["a[0]", "b.b"]) // return {"a": [{"b": 1}], "b": [{"b": -1}, {"b": -2}, {"b": -3}]}
+//
+//
+// Keys
+//
+// Returns a list of the keys of the receiver in sorted order:
+//
+//     <map<K,V>>.keys() -> <list<K>>
+//
+// Examples:
+//
+//     {"b": 2, "a": 1}.keys()  // return ["a", "b"]
+//
+//
+// Last
+//
+// Returns the last element of a list, the last character of a string or
+// the last byte of bytes. If the receiver is empty, the optional parameter
+// is returned, or an error if it is not provided:
+//
+//     <list<V>>.last() -> <V>
+//     <list<V>>.last(<V>) -> <V>
+//     <string>.last() -> <string>
+//     <string>.last(<string>) -> <string>
+//     <bytes>.last() -> <bytes>
+//     <bytes>.last(<bytes>) -> <bytes>
+//
+// Examples:
+//
+//     [1, 2, 3].last()  // return 3
+//     [].last(0)        // return 0
+//     "hello".last()    // return "o"
+//
+//
+// Map Keys (Macro)
+//
+// Returns a map with the keys of the receiver replaced by the result of
+// evaluating the expression with the identifier bound to the key. It is an
+// error for the expression to return the same key for more than one field:
+//
+//     <map<K,V>>.map_keys(<ident>, <expr>) -> <map<dyn,V>>
+//
+// Examples:
+//
+//     {"Type": ["text/plain"]}.map_keys(k, "Content-" + k)  // return {"Content-Type": ["text/plain"]}
+//     {"a": 1, "b": 2}.map_keys(k, "c")                     // return error
+//
+//
+// Map Values (Macro)
+//
+// Returns a map with the values of the receiver replaced by the result of
+// evaluating the expression with the identifier bound to the value:
+//
+//     <map<K,V>>.map_values(<ident>, <expr>) -> <map<K,dyn>>
+//
+// Examples:
+//
+//     {"a": 1, "b": 2}.map_values(v, v * 2)           // return {"a": 2, "b": 4}
+//     {"Accept": ["a", "b"]}.map_values(v, v[0])      // return {"Accept": "a"}
+//
+//
 // Max
 //
 // Returns the maximum value of a list of comparable objects:
@@ -149,6 +524,79 @@ import (
 //     max([1,2,3,4,5,6,7])   // return 7
 //
 //
+// Mean
+//
+// Returns the arithmetic mean of a list of numbers or durations. Null elements
+// are ignored. The mean of numbers is a double and the mean of durations is
+// a duration. It is an error to take the mean of an empty list:
+//
+//     <list<dyn>>.mean() -> <dyn>
+//     mean(<list<dyn>>) -> <dyn>
+//
+// Examples:
+//
+//     [1, 2, 3, 4].mean()                       // return 2.5
+//     [duration("1s"), duration("2s")].mean()  // return duration("1.5s")
+//
+//
+// Median
+//
+// Returns the median of a list of numbers or durations. Null elements are
+// ignored. The median of numbers is a double and the median of durations is
+// a duration. It is an error to take the median of an empty list:
+//
+//     <list<dyn>>.median() -> <dyn>
+//     median(<list<dyn>>) -> <dyn>
+//
+// Examples:
+//
+//     [3, 1, 2].median()     // return 2.0
+//     [4, 1, 3, 2].median()  // return 2.5
+//
+//
+// Merge
+//
+// Returns the receiver's value deeply merged with the value of the parameter.
+// Maps are merged recursively. Values present in both that are not maps, or
+// are lists when lists are not merged, are resolved according to the conflict
+// strategy. An optional map of options may be provided to configure the
+// strategies:
+//
+//     <map<K,V>>.merge(<map<K,V>>) -> <map<K,V>>
+//     <map<K,V>>.merge(<map<K,V>>, <map<string,dyn>>) -> <map<K,V>>
+//
+// The options are:
+//
+//     "conflict": how conflicting values are resolved, one of
+//         "right": the parameter's value is used (default)
+//         "left":  the receiver's value is used
+//         "error": the merge fails unless the values are equal
+//     "lists": how lists present in both are merged, one of
+//         "replace": lists are treated as conflicting values (default)
+//         "append":  the parameter's elements are appended to the receiver's
+//         "index":   elements at the same index are merged
+//         "key":     map elements with equal values at the "key" path are
+//                    merged and other elements are appended
+//     "key": the field path used to match elements for the "key" list strategy
+//
+// Examples:
+//
+//     {"a": {"b": 1, "c": 2}}.merge({"a": {"b": 10, "d": 3}})                           // return {"a": {"b": 10, "c": 2, "d": 3}}
+//     {"a": {"b": 1, "c": 2}}.merge({"a": {"b": 10, "d": 3}}, {"conflict": "left"})     // return {"a": {"b": 1, "c": 2, "d": 3}}
+//     {"a": {"b": 1, "c": 2}}.merge({"a": {"b": 10, "d": 3}}, {"conflict": "error"})    // return error
+//     {"a": [1, 2]}.merge({"a": [3]}, {"lists": "append"})                              // return {"a": [1, 2, 3]}
+//     {"a": [{"x": 1}, {"x": 2}]}.merge({"a": [{"y": 3}]}, {"lists": "index"})          // return {"a": [{"x": 1, "y": 3}, {"x": 2}]}
+//
+//     {"a": [{"id": 1, "x": 1}, {"id": 2, "x": 2}]}.merge(
+//         {"a": [{"id": 2, "y": 3}, {"id": 3}]},
+//         {"lists": "key", "key": "id"}
+//     )
+//
+//     will return:
+//
+//     {"a": [{"id": 1, "x": 1}, {"id": 2, "x": 2, "y": 3}, {"id": 3}]}
+//
+//
 // Min
 //
 // Returns the minimum value of a list of comparable objects:
@@ -162,6 +610,40 @@ import (
 //     min([1,2,3,4,5,6,7])   // return 1
 //
 //
+// Percentile
+//
+// Returns the p'th percentile of a list of numbers or durations, with p in the
+// range [0, 100]. Values between elements are linearly interpolated. Null
+// elements are ignored. The percentile of numbers is a double and the
+// percentile of durations is a duration. It is an error to take the percentile
+// of an empty list:
+//
+//     <list<dyn>>.percentile(<double>) -> <dyn>
+//     <list<dyn>>.percentile(<int>) -> <dyn>
+//     percentile(<list<dyn>>, <double>) -> <dyn>
+//     percentile(<list<dyn>>, <int>) -> <dyn>
+//
+// Examples:
+//
+//     [1, 2, 3, 4, 5].percentile(90)    // return 4.6
+//     [1, 2, 3, 4, 5].percentile(50.0)  // return 3.0
+//
+//
+// Product
+//
+// Returns a list of all the combinations of one element from each of the
+// lists in the parameter, the Cartesian product of the lists. Combinations
+// are ordered with the elements of the last list varying fastest. The
+// returned list is lazily evaluated:
+//
+//     product(<list<list<dyn>>>) -> <list<list<dyn>>>
+//
+// Examples:
+//
+//     product([[1, 2], ["a", "b"]])  // return [[1, "a"], [1, "b"], [2, "a"], [2, "b"]]
+//     product([[1, 2], []])          // return []
+//
+//
 // Range
 //
 // Returns an iterator over the indexes of a set of equally sized lists. The
@@ -190,729 +672,3511 @@ import (
 //     {"error": "mismatched length in range call: 2 != 1"}
 //
 //
-// With
+// Reduce (Macro)
 //
-// Returns the receiver's value with the value of the parameter updating
-// or adding fields:
+// The reduce macro folds the elements of the receiver into an accumulated
+// value. The accumulator identifier is initialised with the init expression
+// and is then replaced with the result of evaluating the step expression for
+// each element of the receiver in order. The final value of the accumulator
+// is returned:
 //
-//     <map<K,V>>.with(<map<K,V>>) -> <map<K,V>>
+//     <list<dyn>>.reduce(<ident>, <ident>, <init>, <expr>) -> <dyn>
 //
 // Examples:
 //
-//     {"a":1, "b":2}.with({"a":10, "c":3})  // return {"a":10, "b":2, "c":3}
+//     [1, 2, 3, 4].reduce(a, e, 0, a + e)             // return 10
+//     [{"a":1}, {"b":2}].reduce(a, e, {}, a.with(e))  // return {"a":1, "b":2}
 //
+//     [3, 1, 4, 1, 5].reduce(a, e, [], a + [size(a) == 0 || e > a[size(a)-1] ? e : a[size(a)-1]])
 //
-// With Replace
+//     will return the running maximum:
 //
-// Returns the receiver's value with the value of the parameter replacing
-// existing fields:
+//     [3, 3, 4, 4, 5]
 //
-//     <map<K,V>>.with(<map<K,V>>) -> <map<K,V>>
+//
+// Rename
+//
+// Returns the receiver's value with the values at the paths in the keys of
+// the parameter moved to the corresponding path in the values. All values
+// are obtained from the receiver before any are moved. Paths that do not
+// exist in the receiver are ignored. When the receiver is a list, each
+// element is renamed:
+//
+//     <list<dyn>>.rename(<map<string,string>>) -> <list<dyn>>
+//     <map<string,dyn>>.rename(<map<string,string>>) -> <map<string,dyn>>
 //
 // Examples:
 //
-//     {"a":1, "b":2}.with({"a":10, "c":3})  // return {"a":10, "b":2}
+//     {"a": {"b": 1}, "c": 2}.rename({"a.b": "x.y", "c": "d"})  // return {"a": {}, "d": 2, "x": {"y": 1}}
+//     {"a": 1, "b": 2}.rename({"a": "b", "b": "a"})             // return {"a": 2, "b": 1}
 //
 //
-// With Update
+// Set Path
 //
-// Returns the receiver's value with the value of the parameter updating
-// the map without replacing any existing fields:
+// Returns the receiver's value with the value at the given path set to the
+// value of the second parameter. Maps are created for missing keys in the
+// path, but lists are not created or extended. The path must address a
+// single value, so wildcards, recursive descent and the empty path are not
+// allowed:
 //
-//     <map<K,V>>.with(<map<K,V>>) -> <map<K,V>>
+//     <list<dyn>>.set_path(<string>, <dyn>) -> <list<dyn>>
+//     <map<string,dyn>>.set_path(<string>, <dyn>) -> <map<string,dyn>>
 //
 // Examples:
 //
-//     {"a":1, "b":2}.with({"a":10, "c":3})  // return {"a":1, "b":2, "c":3}
+//     {"a": 1}.set_path("b.c", 2)       // return {"a": 1, "b": {"c": 2}}
+//     {"a": [1, 2]}.set_path("a[0]", 3) // return {"a": [3, 2]}
+//     {"a": 1}.set_path("a.b", 2)       // return error
 //
-func Collections() cel.EnvOption {
-	return cel.Lib(collectionsLib{})
-}
-
-type collectionsLib struct{}
-
-func (collectionsLib) CompileOptions() []cel.EnvOption {
-	return []cel.EnvOption{
-		cel.Macros(parser.NewReceiverMacro("as", 2, makeAs)),
-		cel.Declarations(
-			decls.NewFunction("collate",
-				decls.NewParameterizedInstanceOverload(
-					"list_collate_string",
-					[]*expr.Type{decls.NewListType(decls.Dyn), decls.String},
-					listV,
-					[]string{"V"},
-				),
-				decls.NewParameterizedInstanceOverload(
-					"list_collate_list_string",
-					[]*expr.Type{decls.NewListType(decls.Dyn), decls.NewListType(decls.String)},
-					listV,
-					[]string{"V"},
-				),
-				decls.NewParameterizedInstanceOverload(
-					"map_collate_string",
-					[]*expr.Type{mapStringDyn, decls.String},
-					listV,
-					[]string{"V"},
-				),
-				decls.NewParameterizedInstanceOverload(
-					"map_collate_list_string",
-					[]*expr.Type{mapStringDyn, decls.NewListType(decls.String)},
-					listV,
-					[]string{"V"},
-				),
-			),
-			decls.NewFunction("drop",
-				decls.NewInstanceOverload(
-					"list_drop_string",
-					[]*expr.Type{decls.NewListType(decls.Dyn), decls.String},
-					decls.NewListType(decls.Dyn),
+//
+// Skip
+//
+// Returns the receiver without its first n elements, characters or bytes. If
+// n is negative, the last -n elements are removed instead. If n is greater
+// than the length of the receiver, an empty value is returned:
+//
+//     <list<V>>.skip(<int>) -> <list<V>>
+//     <string>.skip(<int>) -> <string>
+//     <bytes>.skip(<int>) -> <bytes>
+//
+// Examples:
+//
+//     [["id", "name"], [1, "a"], [2, "b"]].skip(1)  // return [[1, "a"], [2, "b"]]
+//     [1, 2, 3].skip(-1)                            // return [1, 2]
+//     "hello".skip(3)                               // return "lo"
+//
+//
+// Slice
+//
+// Returns the elements, characters or bytes of the receiver from the start
+// index up to but not including the end index. Negative indexes count back
+// from the end of the receiver. Indexes beyond the bounds of the receiver are
+// clamped to the bounds and if the start is not before the end, an empty
+// value is returned:
+//
+//     <list<V>>.slice(<int>, <int>) -> <list<V>>
+//     <string>.slice(<int>, <int>) -> <string>
+//     <bytes>.slice(<int>, <int>) -> <bytes>
+//
+// Examples:
+//
+//     [1, 2, 3, 4, 5].slice(1, 3)    // return [2, 3]
+//     [1, 2, 3, 4, 5].slice(-2, 10)  // return [4, 5]
+//     "hello".slice(1, -1)           // return "ell"
+//     b"hello".slice(0, 2)           // return b"he"
+//
+//
+// Stddev
+//
+// Returns the population standard deviation of a list of numbers or durations.
+// Null elements are ignored. The standard deviation of numbers is a double and
+// the standard deviation of durations is a duration. It is an error to take
+// the standard deviation of an empty list:
+//
+//     <list<dyn>>.stddev() -> <dyn>
+//     stddev(<list<dyn>>) -> <dyn>
+//
+// Examples:
+//
+//     [2, 4, 4, 4, 5, 5, 7, 9].stddev()  // return 2.0
+//
+//
+// Sum
+//
+// Returns the sum of a list of numbers or durations. Null elements are
+// ignored. The sum of a list of ints or uints is an int or uint respectively,
+// the sum of a list of durations is a duration, and the sum of any other mix
+// of numbers is a double. The sum of an empty list is the int zero:
+//
+//     <list<dyn>>.sum() -> <dyn>
+//     sum(<list<dyn>>) -> <dyn>
+//
+// Examples:
+//
+//     [1, 2, 3].sum()                          // return 6
+//     [1, 2.5].sum()                           // return 3.5
+//     [duration("1s"), duration("2s")].sum()  // return duration("3s")
+//
+//
+// Symmetric Difference
+//
+// Returns a list of the unique elements that are in either the receiver or
+// the parameter but not in both. Elements from the receiver appear first,
+// followed by elements from the parameter, each in the order that they first
+// appear. Elements are compared using CEL equality:
+//
+//     <list<dyn>>.symmetric_difference(<list<dyn>>) -> <list<dyn>>
+//
+// Examples:
+//
+//     [1, 2, 3].symmetric_difference([2, 3, 4])  // return [1, 4]
+//
+//
+// Take
+//
+// Returns the first n elements, characters or bytes of the receiver. If n is
+// negative, the last -n elements are returned instead. If n is greater than
+// the length of the receiver, the receiver is returned:
+//
+//     <list<V>>.take(<int>) -> <list<V>>
+//     <string>.take(<int>) -> <string>
+//     <bytes>.take(<int>) -> <bytes>
+//
+// Examples:
+//
+//     [1, 2, 3, 4, 5].take(2)   // return [1, 2]
+//     [1, 2, 3, 4, 5].take(-2)  // return [4, 5]
+//     "hello".take(10)          // return "hello"
+//
+//
+// To Map (Macro)
+//
+// Returns a map constructed from the elements of the receiver with keys and
+// values obtained by evaluating the key and value expressions with the
+// identifier bound to the element. An optional policy determines how elements
+// with the same key are handled as described for index_by:
+//
+//     <list<V>>.to_map(<ident>, <expr>, <expr>) -> <map<dyn,dyn>>
+//     <list<V>>.to_map(<ident>, <expr>, <expr>, <string>) -> <map<dyn,dyn>>
+//
+// Examples:
+//
+//     [{"id": 1, "name": "a"}, {"id": 2, "name": "b"}].to_map(e, e.id, e.name)
+//                                                         // return {1: "a", 2: "b"}
+//     [["a", 1], ["b", 2], ["a", 3]].to_map(e, e[0], e[1], "collect")
+//                                                         // return {"a": [1, 3], "b": [2]}
+//
+//
+// Unflatten Keys
+//
+// Returns a nested map constructed from the keys of the receiver. This is the
+// inverse of flatten_keys. Missing list elements are filled with null. Keys
+// that describe conflicting structure and list indexes that are not less than
+// the number of keys in the receiver are an error:
+//
+//     <map<string,dyn>>.unflatten_keys() -> <map<string,dyn>>
+//     <map<string,dyn>>.unflatten_keys(<string>) -> <map<string,dyn>>
+//
+// Examples:
+//
+//     {"a.b": 1, "a.c[0]": 2, "a.c[1].d": 3}.unflatten_keys()  // return {"a": {"b": 1, "c": [2, {"d": 3}]}}
+//     {"a_b": 1, "a_e": {}}.unflatten_keys("_")                // return {"a": {"b": 1, "e": {}}}
+//     {"a": 1, "a.b": 2}.unflatten_keys()                      // return error
+//
+//
+// Union
+//
+// Returns a list of the unique elements that are in either the receiver or
+// the parameter. Elements from the receiver appear first, followed by the
+// elements from the parameter that are not in the receiver, each in the
+// order that they first appear. Elements are compared using CEL equality:
+//
+//     <list<dyn>>.union(<list<dyn>>) -> <list<dyn>>
+//
+// Examples:
+//
+//     [1, 2, 2].union([2, 3, 1])                 // return [1, 2, 3]
+//     ["a", "b"].union(["c"])                    // return ["a", "b", "c"]
+//
+//
+// Unzip
+//
+// Returns a list of lists where the i'th list holds the i'th element of each
+// of the lists in the parameter. It is the inverse of zip. It is an error for
+// the lists in the parameter to have different lengths:
+//
+//     unzip(<list<list<dyn>>>) -> <list<list<dyn>>>
+//
+// Examples:
+//
+//     unzip([[1, "a"], [2, "b"]])  // return [[1, 2], ["a", "b"]]
+//     unzip([])                    // return []
+//
+//
+// Values
+//
+// Returns a list of the values of the receiver in the sorted order of their
+// keys:
+//
+//     <map<K,V>>.values() -> <list<V>>
+//
+// Examples:
+//
+//     {"b": 2, "a": 1}.values()  // return [1, 2]
+//
+//
+// Walk (Macro)
+//
+// The walk and walk_nodes macros return the receiver with values replaced by
+// the result of evaluating the expression for each value. The first identifier
+// is bound to the field path of the value and the second to the value. A null
+// result deletes the value. The walk macro visits each leaf value, which is
+// any value that is not a map or list, while walk_nodes additionally visits
+// every map and list below the receiver. Values are visited in depth-first
+// order with map keys in sorted order. If walk_nodes replaces a map or list
+// with a different value, the results for values within the original map or
+// list are not used:
+//
+//     <dyn>.walk(<ident>, <ident>, <expr>) -> <dyn>
+//     <dyn>.walk_nodes(<ident>, <ident>, <expr>) -> <dyn>
+//
+// Examples:
+//
+//     {"a": "hello", "b": ["world", 1]}.walk(p, v, type(v) == string ? v.size() : v)
+//                                                    // return {"a": 5, "b": [5, 1]}
+//     {"a": "1", "b": {"c": "2"}}.walk(p, v, p == "b.c" ? null : v)
+//                                                    // return {"a": "1", "b": {}}
+//     {"a": [1, 2], "b": {}}.walk_nodes(p, v, v == {} ? null : v)
+//                                                    // return {"a": [1, 2]}
+//
+//
+// Window
+//
+// Returns a list of sliding windows over the receiver with the given number
+// of elements. Successive windows start the given step number of elements
+// apart, with a default step of one. Only complete windows are returned. The
+// returned list is lazily evaluated:
+//
+//     <list<dyn>>.window(<int>) -> <list<list<dyn>>>
+//     <list<dyn>>.window(<int>, <int>) -> <list<list<dyn>>>
+//
+// Examples:
+//
+//     [1, 2, 3, 4, 5].window(3)     // return [[1, 2, 3], [2, 3, 4], [3, 4, 5]]
+//     [1, 2, 3, 4, 5].window(2, 2)  // return [[1, 2], [3, 4]]
+//     [1, 2].window(3)              // return []
+//
+//
+// With
+//
+// Returns the receiver's value with the value of the parameter updating
+// or adding fields:
+//
+//     <map<K,V>>.with(<map<K,V>>) -> <map<K,V>>
+//
+// Examples:
+//
+//     {"a":1, "b":2}.with({"a":10, "c":3})  // return {"a":10, "b":2, "c":3}
+//
+//
+// With Replace
+//
+// Returns the receiver's value with the value of the parameter replacing
+// existing fields:
+//
+//     <map<K,V>>.with(<map<K,V>>) -> <map<K,V>>
+//
+// Examples:
+//
+//     {"a":1, "b":2}.with({"a":10, "c":3})  // return {"a":10, "b":2}
+//
+//
+// With Update
+//
+// Returns the receiver's value with the value of the parameter updating
+// the map without replacing any existing fields:
+//
+//     <map<K,V>>.with(<map<K,V>>) -> <map<K,V>>
+//
+// Examples:
+//
+//     {"a":1, "b":2}.with({"a":10, "c":3})  // return {"a":1, "b":2, "c":3}
+//
+//
+// Zip
+//
+// Returns a list of lists where the i'th list holds the i'th element of
+// each of the lists in the parameter. The zip function stops at the end of
+// the shortest list, while zip_longest continues to the end of the longest
+// list, using null for missing elements. The returned list is lazily
+// evaluated:
+//
+//     zip(<list<list<dyn>>>) -> <list<list<dyn>>>
+//     zip_longest(<list<list<dyn>>>) -> <list<list<dyn>>>
+//
+// Examples:
+//
+//     zip([[1, 2, 3], ["a", "b"]])          // return [[1, "a"], [2, "b"]]
+//     zip_longest([[1, 2, 3], ["a", "b"]])  // return [[1, "a"], [2, "b"], [3, null]]
+//
+func Collections() cel.EnvOption {
+	return cel.Lib(collectionsLib{})
+}
+
+type collectionsLib struct{}
+
+func (collectionsLib) CompileOptions() []cel.EnvOption {
+	return []cel.EnvOption{
+		cel.Macros(
+			parser.NewReceiverMacro("as", 2, makeAs),
+			parser.NewReceiverMacro("distinct_by", 2, makeDistinctBy(distinctByFirstFunc)),
+			parser.NewReceiverMacro("distinct_by_last", 2, makeDistinctBy(distinctByLastFunc)),
+			parser.NewReceiverMacro("filter_entries", 3, makeFilterEntries),
+			parser.NewReceiverMacro("index_by", 2, makeIndexBy),
+			parser.NewReceiverMacro("index_by", 3, makeIndexBy),
+			parser.NewReceiverMacro("join", 4, makeJoin),
+			parser.NewReceiverMacro("join", 5, makeJoin),
+			parser.NewReceiverMacro("map_keys", 2, makeMapKeys),
+			parser.NewReceiverMacro("map_values", 2, makeMapValues),
+			parser.NewReceiverMacro("reduce", 4, makeReduce),
+			parser.NewReceiverMacro("to_map", 3, makeToMap),
+			parser.NewReceiverMacro("to_map", 4, makeToMap),
+			parser.NewReceiverMacro("walk", 3, makeWalk(walkLeavesFunc)),
+			parser.NewReceiverMacro("walk_nodes", 3, makeWalk(walkNodesFunc)),
+		),
+		cel.Declarations(
+			decls.NewFunction("keys",
+				decls.NewParameterizedInstanceOverload(
+					"map_keys",
+					[]*expr.Type{mapKV},
+					decls.NewListType(typeK),
+					[]string{"K", "V"},
+				),
+			),
+			decls.NewFunction("values",
+				decls.NewParameterizedInstanceOverload(
+					"map_values",
+					[]*expr.Type{mapKV},
+					listV,
+					[]string{"K", "V"},
+				),
+			),
+			decls.NewFunction("entries",
+				decls.NewParameterizedInstanceOverload(
+					"map_entries",
+					[]*expr.Type{mapKV},
+					decls.NewListType(mapStringDyn),
+					[]string{"K", "V"},
 				),
+			),
+			decls.NewFunction("from_entries",
 				decls.NewInstanceOverload(
-					"list_drop_list_string",
-					[]*expr.Type{decls.NewListType(decls.Dyn), decls.NewListType(decls.String)},
+					"list_from_entries",
+					[]*expr.Type{decls.NewListType(decls.Dyn)},
+					decls.NewMapType(decls.Dyn, decls.Dyn),
+				),
+			),
+			decls.NewFunction(entriesToMapFunc,
+				decls.NewOverload(
+					"entries_to_map_list",
+					[]*expr.Type{decls.NewListType(decls.NewListType(decls.Dyn))},
+					decls.NewMapType(decls.Dyn, decls.Dyn),
+				),
+			),
+			decls.NewFunction(toMapFunc,
+				decls.NewOverload(
+					"to_map_list_string",
+					[]*expr.Type{decls.NewListType(decls.NewListType(decls.Dyn)), decls.String},
+					decls.NewMapType(decls.Dyn, decls.Dyn),
+				),
+			),
+			decls.NewFunction(joinKeysFunc,
+				decls.NewOverload(
+					"join_keys_list_list_list_list_string",
+					[]*expr.Type{
+						decls.NewListType(decls.Dyn), decls.NewListType(decls.Dyn),
+						decls.NewListType(decls.Dyn), decls.NewListType(decls.Dyn),
+						decls.String,
+					},
 					decls.NewListType(decls.Dyn),
 				),
+			),
+			decls.NewFunction(joinMatchesFunc,
+				decls.NewOverload(
+					"join_matches_list_list_list_string",
+					[]*expr.Type{
+						decls.NewListType(decls.Dyn), decls.NewListType(decls.Dyn),
+						decls.NewListType(decls.NewListType(decls.Bool)),
+						decls.String,
+					},
+					decls.NewListType(decls.Dyn),
+				),
+			),
+			decls.NewFunction("slice",
+				decls.NewParameterizedInstanceOverload(
+					"list_slice_int_int",
+					[]*expr.Type{listV, decls.Int, decls.Int},
+					listV,
+					[]string{"V"},
+				),
 				decls.NewInstanceOverload(
-					"map_drop_string",
-					[]*expr.Type{mapKV, decls.String},
-					mapKV,
+					"string_slice_int_int",
+					[]*expr.Type{decls.String, decls.Int, decls.Int},
+					decls.String,
 				),
 				decls.NewInstanceOverload(
-					"map_drop_list_string",
-					[]*expr.Type{mapKV, decls.NewListType(decls.String)},
-					mapKV,
+					"bytes_slice_int_int",
+					[]*expr.Type{decls.Bytes, decls.Int, decls.Int},
+					decls.Bytes,
 				),
 			),
-			decls.NewFunction("drop_empty",
+			decls.NewFunction("take",
+				decls.NewParameterizedInstanceOverload(
+					"list_take_int",
+					[]*expr.Type{listV, decls.Int},
+					listV,
+					[]string{"V"},
+				),
 				decls.NewInstanceOverload(
-					"list_drop_empty",
-					[]*expr.Type{decls.NewListType(decls.Dyn)},
-					decls.NewListType(decls.Dyn),
+					"string_take_int",
+					[]*expr.Type{decls.String, decls.Int},
+					decls.String,
 				),
 				decls.NewInstanceOverload(
-					"map_drop_empty",
-					[]*expr.Type{mapKV},
-					mapKV,
+					"bytes_take_int",
+					[]*expr.Type{decls.Bytes, decls.Int},
+					decls.Bytes,
 				),
 			),
-			decls.NewFunction("flatten",
+			decls.NewFunction("skip",
+				decls.NewParameterizedInstanceOverload(
+					"list_skip_int",
+					[]*expr.Type{listV, decls.Int},
+					listV,
+					[]string{"V"},
+				),
 				decls.NewInstanceOverload(
-					"list_flatten",
-					[]*expr.Type{decls.NewListType(decls.Dyn)},
-					decls.NewListType(decls.Dyn),
+					"string_skip_int",
+					[]*expr.Type{decls.String, decls.Int},
+					decls.String,
+				),
+				decls.NewInstanceOverload(
+					"bytes_skip_int",
+					[]*expr.Type{decls.Bytes, decls.Int},
+					decls.Bytes,
 				),
 			),
-			decls.NewFunction("max",
+			decls.NewFunction("first",
 				decls.NewParameterizedInstanceOverload(
-					"list_max",
+					"list_first",
 					[]*expr.Type{listV},
 					typeV,
 					[]string{"V"},
 				),
-				decls.NewParameterizedOverload(
-					"max_list",
-					[]*expr.Type{listV},
+				decls.NewInstanceOverload(
+					"string_first",
+					[]*expr.Type{decls.String},
+					decls.String,
+				),
+				decls.NewInstanceOverload(
+					"bytes_first",
+					[]*expr.Type{decls.Bytes},
+					decls.Bytes,
+				),
+				decls.NewParameterizedInstanceOverload(
+					"list_first_dyn",
+					[]*expr.Type{listV, typeV},
 					typeV,
 					[]string{"V"},
 				),
+				decls.NewInstanceOverload(
+					"string_first_string",
+					[]*expr.Type{decls.String, decls.String},
+					decls.String,
+				),
+				decls.NewInstanceOverload(
+					"bytes_first_bytes",
+					[]*expr.Type{decls.Bytes, decls.Bytes},
+					decls.Bytes,
+				),
 			),
-			decls.NewFunction("min",
+			decls.NewFunction("last",
 				decls.NewParameterizedInstanceOverload(
-					"list_min",
+					"list_last",
 					[]*expr.Type{listV},
 					typeV,
 					[]string{"V"},
 				),
-				decls.NewParameterizedOverload(
-					"min_list",
-					[]*expr.Type{listV},
+				decls.NewInstanceOverload(
+					"string_last",
+					[]*expr.Type{decls.String},
+					decls.String,
+				),
+				decls.NewInstanceOverload(
+					"bytes_last",
+					[]*expr.Type{decls.Bytes},
+					decls.Bytes,
+				),
+				decls.NewParameterizedInstanceOverload(
+					"list_last_dyn",
+					[]*expr.Type{listV, typeV},
 					typeV,
 					[]string{"V"},
 				),
+				decls.NewInstanceOverload(
+					"string_last_string",
+					[]*expr.Type{decls.String, decls.String},
+					decls.String,
+				),
+				decls.NewInstanceOverload(
+					"bytes_last_bytes",
+					[]*expr.Type{decls.Bytes, decls.Bytes},
+					decls.Bytes,
+				),
 			),
-			decls.NewFunction("with",
+			decls.NewFunction("chunk",
 				decls.NewParameterizedInstanceOverload(
-					"map_with_map",
-					[]*expr.Type{mapKV, mapKV},
-					mapKV,
-					[]string{"K", "V"},
+					"list_chunk_int",
+					[]*expr.Type{listV, decls.Int},
+					decls.NewListType(listV),
+					[]string{"V"},
 				),
 			),
-			decls.NewFunction("with_update",
+			decls.NewFunction("window",
 				decls.NewParameterizedInstanceOverload(
-					"map_with_update_map",
-					[]*expr.Type{mapKV, mapKV},
-					mapKV,
-					[]string{"K", "V"},
+					"list_window_int",
+					[]*expr.Type{listV, decls.Int},
+					decls.NewListType(listV),
+					[]string{"V"},
 				),
-			),
-			decls.NewFunction("with_replace",
 				decls.NewParameterizedInstanceOverload(
-					"map_with_replace_map",
-					[]*expr.Type{mapKV, mapKV},
-					mapKV,
-					[]string{"K", "V"},
+					"list_window_int_int",
+					[]*expr.Type{listV, decls.Int, decls.Int},
+					decls.NewListType(listV),
+					[]string{"V"},
 				),
 			),
-			decls.NewFunction("range",
+			decls.NewFunction("collate",
+				decls.NewParameterizedInstanceOverload(
+					"list_collate_string",
+					[]*expr.Type{decls.NewListType(decls.Dyn), decls.String},
+					listV,
+					[]string{"V"},
+				),
+				decls.NewParameterizedInstanceOverload(
+					"list_collate_list_string",
+					[]*expr.Type{decls.NewListType(decls.Dyn), decls.NewListType(decls.String)},
+					listV,
+					[]string{"V"},
+				),
+				decls.NewParameterizedInstanceOverload(
+					"map_collate_string",
+					[]*expr.Type{mapStringDyn, decls.String},
+					listV,
+					[]string{"V"},
+				),
+				decls.NewParameterizedInstanceOverload(
+					"map_collate_list_string",
+					[]*expr.Type{mapStringDyn, decls.NewListType(decls.String)},
+					listV,
+					[]string{"V"},
+				),
+			),
+			decls.NewFunction("distinct",
+				decls.NewInstanceOverload(
+					"list_distinct",
+					[]*expr.Type{decls.NewListType(decls.Dyn)},
+					decls.NewListType(decls.Dyn),
+				),
+			),
+			decls.NewFunction(distinctByFirstFunc,
 				decls.NewOverload(
-					"range_list_list",
+					"distinct_by_first_list_list",
 					[]*expr.Type{decls.NewListType(decls.NewListType(decls.Dyn))},
-					decls.NewListType(decls.Int),
+					decls.NewListType(decls.Dyn),
 				),
 			),
-		),
+			decls.NewFunction(distinctByLastFunc,
+				decls.NewOverload(
+					"distinct_by_last_list_list",
+					[]*expr.Type{decls.NewListType(decls.NewListType(decls.Dyn))},
+					decls.NewListType(decls.Dyn),
+				),
+			),
+			decls.NewFunction(walkLeavesFunc,
+				decls.NewOverload(
+					"walk_leaves_dyn",
+					[]*expr.Type{decls.Dyn},
+					decls.NewListType(decls.NewListType(decls.Dyn)),
+				),
+			),
+			decls.NewFunction(walkNodesFunc,
+				decls.NewOverload(
+					"walk_nodes_dyn",
+					[]*expr.Type{decls.Dyn},
+					decls.NewListType(decls.NewListType(decls.Dyn)),
+				),
+			),
+			decls.NewFunction(walkRebuildFunc,
+				decls.NewOverload(
+					"walk_rebuild_dyn_list_list",
+					[]*expr.Type{decls.Dyn, decls.NewListType(decls.NewListType(decls.Dyn))},
+					decls.Dyn,
+				),
+			),
+			decls.NewFunction("drop",
+				decls.NewInstanceOverload(
+					"list_drop_string",
+					[]*expr.Type{decls.NewListType(decls.Dyn), decls.String},
+					decls.NewListType(decls.Dyn),
+				),
+				decls.NewInstanceOverload(
+					"list_drop_list_string",
+					[]*expr.Type{decls.NewListType(decls.Dyn), decls.NewListType(decls.String)},
+					decls.NewListType(decls.Dyn),
+				),
+				decls.NewInstanceOverload(
+					"map_drop_string",
+					[]*expr.Type{mapKV, decls.String},
+					mapKV,
+				),
+				decls.NewInstanceOverload(
+					"map_drop_list_string",
+					[]*expr.Type{mapKV, decls.NewListType(decls.String)},
+					mapKV,
+				),
+			),
+			decls.NewFunction("drop_empty",
+				decls.NewInstanceOverload(
+					"list_drop_empty",
+					[]*expr.Type{decls.NewListType(decls.Dyn)},
+					decls.NewListType(decls.Dyn),
+				),
+				decls.NewInstanceOverload(
+					"map_drop_empty",
+					[]*expr.Type{mapKV},
+					mapKV,
+				),
+			),
+			decls.NewFunction("flatten_keys",
+				decls.NewInstanceOverload(
+					"map_flatten_keys",
+					[]*expr.Type{mapStringDyn},
+					mapStringDyn,
+				),
+				decls.NewInstanceOverload(
+					"map_flatten_keys_string",
+					[]*expr.Type{mapStringDyn, decls.String},
+					mapStringDyn,
+				),
+			),
+			decls.NewFunction("unflatten_keys",
+				decls.NewInstanceOverload(
+					"map_unflatten_keys",
+					[]*expr.Type{mapStringDyn},
+					mapStringDyn,
+				),
+				decls.NewInstanceOverload(
+					"map_unflatten_keys_string",
+					[]*expr.Type{mapStringDyn, decls.String},
+					mapStringDyn,
+				),
+			),
+			decls.NewFunction("get_path",
+				decls.NewInstanceOverload(
+					"list_get_path_string",
+					[]*expr.Type{decls.NewListType(decls.Dyn), decls.String},
+					decls.Dyn,
+				),
+				decls.NewInstanceOverload(
+					"list_get_path_string_dyn",
+					[]*expr.Type{decls.NewListType(decls.Dyn), decls.String, decls.Dyn},
+					decls.Dyn,
+				),
+				decls.NewInstanceOverload(
+					"map_get_path_string",
+					[]*expr.Type{mapStringDyn, decls.String},
+					decls.Dyn,
+				),
+				decls.NewInstanceOverload(
+					"map_get_path_string_dyn",
+					[]*expr.Type{mapStringDyn, decls.String, decls.Dyn},
+					decls.Dyn,
+				),
+			),
+			decls.NewFunction("set_path",
+				decls.NewInstanceOverload(
+					"list_set_path_string_dyn",
+					[]*expr.Type{decls.NewListType(decls.Dyn), decls.String, decls.Dyn},
+					decls.NewListType(decls.Dyn),
+				),
+				decls.NewInstanceOverload(
+					"map_set_path_string_dyn",
+					[]*expr.Type{mapStringDyn, decls.String, decls.Dyn},
+					mapStringDyn,
+				),
+			),
+			decls.NewFunction("rename",
+				decls.NewInstanceOverload(
+					"list_rename_map",
+					[]*expr.Type{decls.NewListType(decls.Dyn), decls.NewMapType(decls.String, decls.String)},
+					decls.NewListType(decls.Dyn),
+				),
+				decls.NewInstanceOverload(
+					"map_rename_map",
+					[]*expr.Type{mapStringDyn, decls.NewMapType(decls.String, decls.String)},
+					mapStringDyn,
+				),
+			),
+			decls.NewFunction("keep",
+				decls.NewInstanceOverload(
+					"list_keep_string",
+					[]*expr.Type{decls.NewListType(decls.Dyn), decls.String},
+					decls.NewListType(decls.Dyn),
+				),
+				decls.NewInstanceOverload(
+					"list_keep_list_string",
+					[]*expr.Type{decls.NewListType(decls.Dyn), decls.NewListType(decls.String)},
+					decls.NewListType(decls.Dyn),
+				),
+				decls.NewInstanceOverload(
+					"map_keep_string",
+					[]*expr.Type{mapKV, decls.String},
+					mapKV,
+				),
+				decls.NewInstanceOverload(
+					"map_keep_list_string",
+					[]*expr.Type{mapKV, decls.NewListType(decls.String)},
+					mapKV,
+				),
+			),
+			decls.NewFunction("flatten",
+				decls.NewInstanceOverload(
+					"list_flatten",
+					[]*expr.Type{decls.NewListType(decls.Dyn)},
+					decls.NewListType(decls.Dyn),
+				),
+			),
+			decls.NewFunction("max",
+				decls.NewParameterizedInstanceOverload(
+					"list_max",
+					[]*expr.Type{listV},
+					typeV,
+					[]string{"V"},
+				),
+				decls.NewParameterizedOverload(
+					"max_list",
+					[]*expr.Type{listV},
+					typeV,
+					[]string{"V"},
+				),
+			),
+			decls.NewFunction("min",
+				decls.NewParameterizedInstanceOverload(
+					"list_min",
+					[]*expr.Type{listV},
+					typeV,
+					[]string{"V"},
+				),
+				decls.NewParameterizedOverload(
+					"min_list",
+					[]*expr.Type{listV},
+					typeV,
+					[]string{"V"},
+				),
+			),
+			decls.NewFunction("count",
+				decls.NewInstanceOverload(
+					"list_count",
+					[]*expr.Type{decls.NewListType(decls.Dyn)},
+					decls.Int,
+				),
+				decls.NewOverload(
+					"count_list",
+					[]*expr.Type{decls.NewListType(decls.Dyn)},
+					decls.Int,
+				),
+			),
+			decls.NewFunction("sum",
+				decls.NewInstanceOverload(
+					"list_sum",
+					[]*expr.Type{decls.NewListType(decls.Dyn)},
+					decls.Dyn,
+				),
+				decls.NewOverload(
+					"sum_list",
+					[]*expr.Type{decls.NewListType(decls.Dyn)},
+					decls.Dyn,
+				),
+			),
+			decls.NewFunction("mean",
+				decls.NewInstanceOverload(
+					"list_mean",
+					[]*expr.Type{decls.NewListType(decls.Dyn)},
+					decls.Dyn,
+				),
+				decls.NewOverload(
+					"mean_list",
+					[]*expr.Type{decls.NewListType(decls.Dyn)},
+					decls.Dyn,
+				),
+			),
+			decls.NewFunction("median",
+				decls.NewInstanceOverload(
+					"list_median",
+					[]*expr.Type{decls.NewListType(decls.Dyn)},
+					decls.Dyn,
+				),
+				decls.NewOverload(
+					"median_list",
+					[]*expr.Type{decls.NewListType(decls.Dyn)},
+					decls.Dyn,
+				),
+			),
+			decls.NewFunction("stddev",
+				decls.NewInstanceOverload(
+					"list_stddev",
+					[]*expr.Type{decls.NewListType(decls.Dyn)},
+					decls.Dyn,
+				),
+				decls.NewOverload(
+					"stddev_list",
+					[]*expr.Type{decls.NewListType(decls.Dyn)},
+					decls.Dyn,
+				),
+			),
+			decls.NewFunction("percentile",
+				decls.NewInstanceOverload(
+					"list_percentile_double",
+					[]*expr.Type{decls.NewListType(decls.Dyn), decls.Double},
+					decls.Dyn,
+				),
+				decls.NewOverload(
+					"percentile_list_double",
+					[]*expr.Type{decls.NewListType(decls.Dyn), decls.Double},
+					decls.Dyn,
+				),
+				decls.NewInstanceOverload(
+					"list_percentile_int",
+					[]*expr.Type{decls.NewListType(decls.Dyn), decls.Int},
+					decls.Dyn,
+				),
+				decls.NewOverload(
+					"percentile_list_int",
+					[]*expr.Type{decls.NewListType(decls.Dyn), decls.Int},
+					decls.Dyn,
+				),
+			),
+			decls.NewFunction("merge",
+				decls.NewParameterizedInstanceOverload(
+					"map_merge_map",
+					[]*expr.Type{mapKV, mapKV},
+					mapKV,
+					[]string{"K", "V"},
+				),
+				decls.NewParameterizedInstanceOverload(
+					"map_merge_map_map",
+					[]*expr.Type{mapKV, mapKV, mapStringDyn},
+					mapKV,
+					[]string{"K", "V"},
+				),
+			),
+			decls.NewFunction("with",
+				decls.NewParameterizedInstanceOverload(
+					"map_with_map",
+					[]*expr.Type{mapKV, mapKV},
+					mapKV,
+					[]string{"K", "V"},
+				),
+			),
+			decls.NewFunction("with_update",
+				decls.NewParameterizedInstanceOverload(
+					"map_with_update_map",
+					[]*expr.Type{mapKV, mapKV},
+					mapKV,
+					[]string{"K", "V"},
+				),
+			),
+			decls.NewFunction("with_replace",
+				decls.NewParameterizedInstanceOverload(
+					"map_with_replace_map",
+					[]*expr.Type{mapKV, mapKV},
+					mapKV,
+					[]string{"K", "V"},
+				),
+			),
+			decls.NewFunction("union",
+				decls.NewParameterizedInstanceOverload(
+					"list_union_list",
+					[]*expr.Type{listV, decls.NewListType(decls.Dyn)},
+					decls.NewListType(decls.Dyn),
+					[]string{"V"},
+				),
+			),
+			decls.NewFunction("intersect",
+				decls.NewParameterizedInstanceOverload(
+					"list_intersect_list",
+					[]*expr.Type{listV, decls.NewListType(decls.Dyn)},
+					listV,
+					[]string{"V"},
+				),
+			),
+			decls.NewFunction("difference",
+				decls.NewParameterizedInstanceOverload(
+					"list_difference_list",
+					[]*expr.Type{listV, decls.NewListType(decls.Dyn)},
+					listV,
+					[]string{"V"},
+				),
+			),
+			decls.NewFunction("symmetric_difference",
+				decls.NewParameterizedInstanceOverload(
+					"list_symmetric_difference_list",
+					[]*expr.Type{listV, decls.NewListType(decls.Dyn)},
+					decls.NewListType(decls.Dyn),
+					[]string{"V"},
+				),
+			),
+			decls.NewFunction("range",
+				decls.NewOverload(
+					"range_list_list",
+					[]*expr.Type{decls.NewListType(decls.NewListType(decls.Dyn))},
+					decls.NewListType(decls.Int),
+				),
+			),
+			decls.NewFunction("product",
+				decls.NewOverload(
+					"product_list_list",
+					[]*expr.Type{decls.NewListType(decls.NewListType(decls.Dyn))},
+					decls.NewListType(decls.NewListType(decls.Dyn)),
+				),
+			),
+			decls.NewFunction("zip",
+				decls.NewOverload(
+					"zip_list_list",
+					[]*expr.Type{decls.NewListType(decls.NewListType(decls.Dyn))},
+					decls.NewListType(decls.NewListType(decls.Dyn)),
+				),
+			),
+			decls.NewFunction("zip_longest",
+				decls.NewOverload(
+					"zip_longest_list_list",
+					[]*expr.Type{decls.NewListType(decls.NewListType(decls.Dyn))},
+					decls.NewListType(decls.NewListType(decls.Dyn)),
+				),
+			),
+			decls.NewFunction("unzip",
+				decls.NewOverload(
+					"unzip_list_list",
+					[]*expr.Type{decls.NewListType(decls.NewListType(decls.Dyn))},
+					decls.NewListType(decls.NewListType(decls.Dyn)),
+				),
+			),
+		),
+	}
+}
+
+func (collectionsLib) ProgramOptions() []cel.ProgramOption {
+	return []cel.ProgramOption{
+		cel.Functions(
+			&functions.Overload{
+				Operator: "list_chunk_int",
+				Binary:   chunk,
+			},
+			&functions.Overload{
+				Operator: "list_window_int",
+				Binary: func(list, size ref.Val) ref.Val {
+					return window(list, size, types.IntOne)
+				},
+			},
+			&functions.Overload{
+				Operator: "list_window_int_int",
+				Function: func(args ...ref.Val) ref.Val {
+					if len(args) != 3 {
+						return types.NewErr("no such overload for window")
+					}
+					return window(args[0], args[1], args[2])
+				},
+			},
+		),
+		cel.Functions(
+			&functions.Overload{
+				Operator: "list_collate_string",
+				Binary:   collateFields,
+			},
+			&functions.Overload{
+				Operator: "list_collate_list_string",
+				Binary:   collateFields,
+			},
+			&functions.Overload{
+				Operator: "map_collate_string",
+				Binary:   collateFields,
+			},
+			&functions.Overload{
+				Operator: "map_collate_list_string",
+				Binary:   collateFields,
+			},
+		),
+		cel.Functions(
+			&functions.Overload{
+				Operator: "list_distinct",
+				Unary:    distinct,
+			},
+			&functions.Overload{
+				Operator: "distinct_by_first_list_list",
+				Unary:    distinctByFirst,
+			},
+			&functions.Overload{
+				Operator: "distinct_by_last_list_list",
+				Unary:    distinctByLast,
+			},
+		),
+		cel.Functions(
+			&functions.Overload{
+				Operator: "walk_leaves_dyn",
+				Unary: func(arg ref.Val) ref.Val {
+					return walkValues(arg, false)
+				},
+			},
+			&functions.Overload{
+				Operator: "walk_nodes_dyn",
+				Unary: func(arg ref.Val) ref.Val {
+					return walkValues(arg, true)
+				},
+			},
+			&functions.Overload{
+				Operator: "walk_rebuild_dyn_list_list",
+				Binary:   walkRebuild,
+			},
+		),
+		cel.Functions(
+			&functions.Overload{
+				Operator: "map_keys",
+				Unary:    mapKeys,
+			},
+			&functions.Overload{
+				Operator: "map_values",
+				Unary:    mapValues,
+			},
+			&functions.Overload{
+				Operator: "map_entries",
+				Unary:    mapEntries,
+			},
+			&functions.Overload{
+				Operator: "list_from_entries",
+				Unary:    fromEntries,
+			},
+			&functions.Overload{
+				Operator: "entries_to_map_list",
+				Unary:    entriesToMap,
+			},
+			&functions.Overload{
+				Operator: "to_map_list_string",
+				Binary:   toMap,
+			},
+			&functions.Overload{
+				Operator: "join_keys_list_list_list_list_string",
+				Function: joinKeys,
+			},
+			&functions.Overload{
+				Operator: "join_matches_list_list_list_string",
+				Function: joinMatches,
+			},
+		),
+		cel.Functions(
+			&functions.Overload{
+				Operator: "list_slice_int_int",
+				Function: slice,
+			},
+			&functions.Overload{
+				Operator: "string_slice_int_int",
+				Function: slice,
+			},
+			&functions.Overload{
+				Operator: "bytes_slice_int_int",
+				Function: slice,
+			},
+		),
+		cel.Functions(
+			&functions.Overload{
+				Operator: "list_take_int",
+				Binary:   take,
+			},
+			&functions.Overload{
+				Operator: "string_take_int",
+				Binary:   take,
+			},
+			&functions.Overload{
+				Operator: "bytes_take_int",
+				Binary:   take,
+			},
+		),
+		cel.Functions(
+			&functions.Overload{
+				Operator: "list_skip_int",
+				Binary:   skip,
+			},
+			&functions.Overload{
+				Operator: "string_skip_int",
+				Binary:   skip,
+			},
+			&functions.Overload{
+				Operator: "bytes_skip_int",
+				Binary:   skip,
+			},
+		),
+		cel.Functions(
+			&functions.Overload{
+				Operator: "list_first",
+				Unary: func(arg ref.Val) ref.Val {
+					return first(arg, nil)
+				},
+			},
+			&functions.Overload{
+				Operator: "list_first_dyn",
+				Binary:   first,
+			},
+			&functions.Overload{
+				Operator: "string_first",
+				Unary: func(arg ref.Val) ref.Val {
+					return first(arg, nil)
+				},
+			},
+			&functions.Overload{
+				Operator: "string_first_string",
+				Binary:   first,
+			},
+			&functions.Overload{
+				Operator: "bytes_first",
+				Unary: func(arg ref.Val) ref.Val {
+					return first(arg, nil)
+				},
+			},
+			&functions.Overload{
+				Operator: "bytes_first_bytes",
+				Binary:   first,
+			},
+		),
+		cel.Functions(
+			&functions.Overload{
+				Operator: "list_last",
+				Unary: func(arg ref.Val) ref.Val {
+					return last(arg, nil)
+				},
+			},
+			&functions.Overload{
+				Operator: "list_last_dyn",
+				Binary:   last,
+			},
+			&functions.Overload{
+				Operator: "string_last",
+				Unary: func(arg ref.Val) ref.Val {
+					return last(arg, nil)
+				},
+			},
+			&functions.Overload{
+				Operator: "string_last_string",
+				Binary:   last,
+			},
+			&functions.Overload{
+				Operator: "bytes_last",
+				Unary: func(arg ref.Val) ref.Val {
+					return last(arg, nil)
+				},
+			},
+			&functions.Overload{
+				Operator: "bytes_last_bytes",
+				Binary:   last,
+			},
+		),
+		cel.Functions(
+			&functions.Overload{
+				Operator: "list_drop_string",
+				Binary:   dropFields,
+			},
+			&functions.Overload{
+				Operator: "list_drop_list_string",
+				Binary:   dropFields,
+			},
+			&functions.Overload{
+				Operator: "map_drop_string",
+				Binary:   dropFields,
+			},
+			&functions.Overload{
+				Operator: "map_drop_list_string",
+				Binary:   dropFields,
+			},
+		),
+		cel.Functions(
+			&functions.Overload{
+				Operator: "list_drop_empty",
+				Unary:    dropEmpty,
+			},
+			&functions.Overload{
+				Operator: "map_drop_empty",
+				Unary:    dropEmpty,
+			},
+		),
+		cel.Functions(
+			&functions.Overload{
+				Operator: "map_flatten_keys",
+				Unary: func(arg ref.Val) ref.Val {
+					return flattenKeys(arg, types.String("."))
+				},
+			},
+			&functions.Overload{
+				Operator: "map_flatten_keys_string",
+				Binary:   flattenKeys,
+			},
+			&functions.Overload{
+				Operator: "map_unflatten_keys",
+				Unary: func(arg ref.Val) ref.Val {
+					return unflattenKeys(arg, types.String("."))
+				},
+			},
+			&functions.Overload{
+				Operator: "map_unflatten_keys_string",
+				Binary:   unflattenKeys,
+			},
+		),
+		cel.Functions(
+			&functions.Overload{
+				Operator: "list_get_path_string",
+				Binary: func(arg, path ref.Val) ref.Val {
+					return getPath(arg, path, nil)
+				},
+			},
+			&functions.Overload{
+				Operator: "list_get_path_string_dyn",
+				Function: getPathOrDefault,
+			},
+			&functions.Overload{
+				Operator: "map_get_path_string",
+				Binary: func(arg, path ref.Val) ref.Val {
+					return getPath(arg, path, nil)
+				},
+			},
+			&functions.Overload{
+				Operator: "map_get_path_string_dyn",
+				Function: getPathOrDefault,
+			},
+		),
+		cel.Functions(
+			&functions.Overload{
+				Operator: "list_set_path_string_dyn",
+				Function: setPath,
+			},
+			&functions.Overload{
+				Operator: "map_set_path_string_dyn",
+				Function: setPath,
+			},
+		),
+		cel.Functions(
+			&functions.Overload{
+				Operator: "list_rename_map",
+				Binary:   renameFields,
+			},
+			&functions.Overload{
+				Operator: "map_rename_map",
+				Binary:   renameFields,
+			},
+		),
+		cel.Functions(
+			&functions.Overload{
+				Operator: "list_keep_string",
+				Binary:   keepFields,
+			},
+			&functions.Overload{
+				Operator: "list_keep_list_string",
+				Binary:   keepFields,
+			},
+			&functions.Overload{
+				Operator: "map_keep_string",
+				Binary:   keepFields,
+			},
+			&functions.Overload{
+				Operator: "map_keep_list_string",
+				Binary:   keepFields,
+			},
+		),
+		cel.Functions(
+			&functions.Overload{
+				Operator: "list_flatten",
+				Unary:    flatten,
+			},
+		),
+		cel.Functions(
+			&functions.Overload{
+				Operator: "min_list",
+				Unary:    min,
+			},
+			&functions.Overload{
+				Operator: "list_min",
+				Unary:    min,
+			},
+		),
+		cel.Functions(
+			&functions.Overload{
+				Operator: "max_list",
+				Unary:    max,
+			},
+			&functions.Overload{
+				Operator: "list_max",
+				Unary:    max,
+			},
+		),
+		cel.Functions(
+			&functions.Overload{
+				Operator: "count_list",
+				Unary:    count,
+			},
+			&functions.Overload{
+				Operator: "list_count",
+				Unary:    count,
+			},
+		),
+		cel.Functions(
+			&functions.Overload{
+				Operator: "sum_list",
+				Unary:    sum,
+			},
+			&functions.Overload{
+				Operator: "list_sum",
+				Unary:    sum,
+			},
+		),
+		cel.Functions(
+			&functions.Overload{
+				Operator: "mean_list",
+				Unary:    mean,
+			},
+			&functions.Overload{
+				Operator: "list_mean",
+				Unary:    mean,
+			},
+		),
+		cel.Functions(
+			&functions.Overload{
+				Operator: "median_list",
+				Unary:    median,
+			},
+			&functions.Overload{
+				Operator: "list_median",
+				Unary:    median,
+			},
+		),
+		cel.Functions(
+			&functions.Overload{
+				Operator: "stddev_list",
+				Unary:    stddev,
+			},
+			&functions.Overload{
+				Operator: "list_stddev",
+				Unary:    stddev,
+			},
+		),
+		cel.Functions(
+			&functions.Overload{
+				Operator: "percentile_list_double",
+				Binary:   percentile,
+			},
+			&functions.Overload{
+				Operator: "list_percentile_double",
+				Binary:   percentile,
+			},
+			&functions.Overload{
+				Operator: "percentile_list_int",
+				Binary:   percentile,
+			},
+			&functions.Overload{
+				Operator: "list_percentile_int",
+				Binary:   percentile,
+			},
+		),
+		cel.Functions(
+			&functions.Overload{
+				Operator: "map_merge_map",
+				Binary: func(dst, src ref.Val) ref.Val {
+					return merge(dst, src, nil)
+				},
+			},
+			&functions.Overload{
+				Operator: "map_merge_map_map",
+				Function: mergeWithOptions,
+			},
+		),
+		cel.Functions(
+			&functions.Overload{
+				Operator: "map_with_map",
+				Binary:   withAll,
+			},
+			&functions.Overload{
+				Operator: "map_with_update_map",
+				Binary:   withUpdate,
+			},
+			&functions.Overload{
+				Operator: "map_with_replace_map",
+				Binary:   withReplace,
+			},
+		),
+		cel.Functions(
+			&functions.Overload{
+				Operator: "list_union_list",
+				Binary:   union,
+			},
+			&functions.Overload{
+				Operator: "list_intersect_list",
+				Binary:   intersect,
+			},
+			&functions.Overload{
+				Operator: "list_difference_list",
+				Binary:   difference,
+			},
+			&functions.Overload{
+				Operator: "list_symmetric_difference_list",
+				Binary:   symmetricDifference,
+			},
+		),
+		cel.Functions(
+			&functions.Overload{
+				Operator: "range_list_list",
+				Unary:    rangeIter,
+			},
+		),
+		cel.Functions(
+			&functions.Overload{
+				Operator: "product_list_list",
+				Unary:    product,
+			},
+			&functions.Overload{
+				Operator: "zip_list_list",
+				Unary: func(arg ref.Val) ref.Val {
+					return zipLists(arg, false)
+				},
+			},
+			&functions.Overload{
+				Operator: "zip_longest_list_list",
+				Unary: func(arg ref.Val) ref.Val {
+					return zipLists(arg, true)
+				},
+			},
+			&functions.Overload{
+				Operator: "unzip_list_list",
+				Unary:    unzip,
+			},
+		),
+	}
+}
+
+func flatten(arg ref.Val) ref.Val {
+	obj := arg
+	l, ok := obj.(traits.Lister)
+	if !ok {
+		return types.ValOrErr(obj, "no such overload")
+	}
+	dst := types.NewMutableList(types.DefaultTypeAdapter)
+	flattenParts(dst, l)
+	return dst.ToImmutableList()
+}
+
+func flattenParts(dst traits.MutableLister, val traits.Lister) {
+	it := val.Iterator()
+	for it.HasNext().Value().(bool) {
+		if _, ok := it.Next().(traits.Lister); !ok {
+			dst.Add(val)
+			return
+		}
+	}
+	it = val.Iterator()
+	for it.HasNext() == types.True {
+		flattenParts(dst, it.Next().(traits.Lister))
+	}
+}
+
+func withAll(dst, src ref.Val) ref.Val {
+	new, other, err := with(dst, src)
+	if err != nil {
+		return err
+	}
+	for k, v := range other {
+		new[k] = v
+	}
+	return types.NewRefValMap(types.DefaultTypeAdapter, new)
+}
+
+func withUpdate(dst, src ref.Val) ref.Val {
+	new, other, err := with(dst, src)
+	if err != nil {
+		return err
+	}
+	for k, v := range other {
+		if _, ok := new[k]; ok {
+			continue
+		}
+		new[k] = v
+	}
+	return types.NewRefValMap(types.DefaultTypeAdapter, new)
+}
+
+func withReplace(dst, src ref.Val) ref.Val {
+	new, other, err := with(dst, src)
+	if err != nil {
+		return err
+	}
+	for k, v := range other {
+		if _, ok := new[k]; !ok {
+			continue
+		}
+		new[k] = v
+	}
+	return types.NewRefValMap(types.DefaultTypeAdapter, new)
+}
+
+func mergeWithOptions(args ...ref.Val) ref.Val {
+	if len(args) != 3 {
+		return types.NewErr("no such overload for merge")
+	}
+	return merge(args[0], args[1], args[2])
+}
+
+func merge(dst, src, options ref.Val) ref.Val {
+	if _, ok := dst.(traits.Mapper); !ok {
+		return types.ValOrErr(dst, "no such overload")
+	}
+	if _, ok := src.(traits.Mapper); !ok {
+		return types.ValOrErr(src, "unsupported src type")
+	}
+	m := merger{conflict: "right", lists: "replace"}
+	if options != nil {
+		opts, ok := options.(traits.Mapper)
+		if !ok {
+			return types.ValOrErr(options, "no such overload")
+		}
+		it := opts.Iterator()
+		for it.HasNext() == types.True {
+			k := it.Next()
+			v, ok := opts.Get(k).(types.String)
+			if !ok {
+				return types.NewErr("invalid merge option type for %v: %v", k, opts.Get(k).Type())
+			}
+			switch k {
+			case types.String("conflict"):
+				switch v {
+				case "right", "left", "error":
+					m.conflict = string(v)
+				default:
+					return types.NewErr("invalid merge conflict strategy: %s", v)
+				}
+			case types.String("lists"):
+				switch v {
+				case "replace", "append", "index", "key":
+					m.lists = string(v)
+				default:
+					return types.NewErr("invalid merge list strategy: %s", v)
+				}
+			case types.String("key"):
+				var err error
+				m.key, err = parseSinglePath(v)
+				if err != nil {
+					return types.NewErr("invalid merge key path: %s: %v", v, err)
+				}
+			default:
+				return types.NewErr("invalid merge option: %v", k)
+			}
+		}
+		if m.lists == "key" && len(m.key) == 0 {
+			return types.NewErr("missing key path for merge list key strategy")
+		}
+	}
+	res, err := m.merge(dst, src, nil)
+	if err != nil {
+		return types.NewErr("failed to merge: %v", err)
+	}
+	return res
+}
+
+// merger implements deep merging of CEL values.
+type merger struct {
+	conflict string        // One of "right", "left" or "error".
+	lists    string        // One of "replace", "append", "index" or "key".
+	key      []pathSegment // Key path for the "key" list strategy.
+}
+
+// merge returns the deep merge of dst and src. The path parameter is the
+// location of dst and src in the root values.
+func (m merger) merge(dst, src ref.Val, path []pathSegment) (ref.Val, error) {
+	switch dst := dst.(type) {
+	case traits.Mapper:
+		src, ok := src.(traits.Mapper)
+		if !ok {
+			break
+		}
+		new := make(map[ref.Val]ref.Val)
+		it := dst.Iterator()
+		for it.HasNext() == types.True {
+			k := it.Next()
+			new[k] = dst.Get(k)
+		}
+		for _, k := range sortedKeys(src) {
+			v := src.Get(k)
+			d, ok := dst.Find(k)
+			if !ok {
+				new[k] = v
+				continue
+			}
+			var err error
+			new[k], err = m.merge(d, v, appendKey(path, k))
+			if err != nil {
+				return nil, err
+			}
+		}
+		return types.NewRefValMap(types.DefaultTypeAdapter, new), nil
+
+	case traits.Lister:
+		src, ok := src.(traits.Lister)
+		if !ok {
+			break
+		}
+		switch m.lists {
+		case "append":
+			return dst.Add(src), nil
+		case "index":
+			return m.mergeByIndex(dst, src, path)
+		case "key":
+			return m.mergeByKey(dst, src, path)
+		}
+	}
+
+	if types.Equal(dst, src) == types.True {
+		return dst, nil
+	}
+	switch m.conflict {
+	case "left":
+		return dst, nil
+	case "error":
+		if len(path) == 0 {
+			return nil, errors.New("conflict at root")
+		}
+		return nil, fmt.Errorf("conflict at %s", joinPath(path))
+	default:
+		return src, nil
+	}
+}
+
+func (m merger) mergeByIndex(dst, src traits.Lister, path []pathSegment) (ref.Val, error) {
+	n1 := int(dst.Size().(types.Int))
+	n2 := int(src.Size().(types.Int))
+	n := n1
+	if n2 > n {
+		n = n2
+	}
+	new := make([]ref.Val, n)
+	for i := range new {
+		switch {
+		case i >= n1:
+			new[i] = src.Get(types.Int(i))
+		case i >= n2:
+			new[i] = dst.Get(types.Int(i))
+		default:
+			var err error
+			new[i], err = m.merge(dst.Get(types.Int(i)), src.Get(types.Int(i)), append(path[:len(path):len(path)], pathSegment{kind: indexSegment, index: i}))
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return types.NewRefValList(types.DefaultTypeAdapter, new), nil
+}
+
+func (m merger) mergeByKey(dst, src traits.Lister, path []pathSegment) (ref.Val, error) {
+	var (
+		new  []ref.Val
+		keys []ref.Val // Keys of elements of new.
+	)
+	it := dst.Iterator()
+	for it.HasNext() == types.True {
+		elem := it.Next()
+		k, _ := pathGet(elem, m.key)
+		new = append(new, elem)
+		keys = append(keys, k)
+	}
+	it = src.Iterator()
+outer:
+	for it.HasNext() == types.True {
+		elem := it.Next()
+		k, ok := pathGet(elem, m.key)
+		if ok {
+			for i, dk := range keys {
+				if dk == nil || types.Equal(dk, k) != types.True {
+					continue
+				}
+				var err error
+				new[i], err = m.merge(new[i], elem, append(path[:len(path):len(path)], pathSegment{kind: indexSegment, index: i}))
+				if err != nil {
+					return nil, err
+				}
+				continue outer
+			}
+		}
+		new = append(new, elem)
+		keys = append(keys, k)
+	}
+	return types.NewRefValList(types.DefaultTypeAdapter, new), nil
+}
+
+// appendKey returns a copy of path with a key segment for k appended.
+func appendKey(path []pathSegment, k ref.Val) []pathSegment {
+	return append(path[:len(path):len(path)], pathSegment{kind: keySegment, key: fmt.Sprint(k)})
+}
+
+var refValMap = reflect.TypeOf(map[ref.Val]ref.Val(nil))
+
+func with(dst, src ref.Val) (res, other map[ref.Val]ref.Val, maybe ref.Val) {
+	obj, ok := dst.(traits.Mapper)
+	if !ok {
+		return nil, nil, types.ValOrErr(obj, "no such overload")
+	}
+	val, ok := src.(traits.Mapper)
+	if !ok {
+		return nil, nil, types.ValOrErr(src, "unsupported src type")
+	}
+
+	new := make(map[ref.Val]ref.Val)
+	m, err := obj.ConvertToNative(refValMap)
+	if err != nil {
+		return nil, nil, types.NewErr("unable to convert dst to native: %v", err)
+	}
+	for k, v := range m.(map[ref.Val]ref.Val) {
+		new[k] = v
+	}
+	m, err = val.ConvertToNative(refValMap)
+	if err != nil {
+		return nil, nil, types.NewErr("unable to convert src to native: %v", err)
+	}
+	return new, m.(map[ref.Val]ref.Val), nil
+}
+
+// TODO: Make this configurable to allow map, list and string emptiness and null.
+func dropEmpty(val ref.Val) ref.Val {
+	obj, ok := val.(iterator)
+	if !ok || !hasEmpty(obj) {
+		return val
+	}
+
+	switch obj := val.(type) {
+	case traits.Lister:
+		new := make([]ref.Val, 0, obj.Size().Value().(int64))
+		it := obj.Iterator()
+		for it.HasNext() == types.True {
+			elem := it.Next()
+			switch val := elem.(type) {
+			case iterator:
+				if val.Size() != types.IntZero {
+					res := dropEmpty(val)
+					if v, ok := res.(traits.Sizer); ok {
+						if v.Size() != types.IntZero {
+							new = append(new, res)
+						}
+					} else {
+						new = append(new, res)
+					}
+				}
+			default:
+				new = append(new, val)
+			}
+		}
+		return types.NewRefValList(types.DefaultTypeAdapter, new)
+
+	case traits.Mapper:
+		new := make(map[ref.Val]ref.Val)
+		m, err := obj.ConvertToNative(refValMap)
+		if err != nil {
+			return types.NewErr("unable to convert map to native: %v", err)
+		}
+		for k, v := range m.(map[ref.Val]ref.Val) {
+			switch val := v.(type) {
+			case iterator:
+				if val.Size() != types.IntZero {
+					res := dropEmpty(v)
+					if v, ok := res.(traits.Sizer); ok {
+						if v.Size() != types.IntZero {
+							new[k] = res
+						}
+					} else {
+						new[k] = res
+					}
+				}
+			default:
+				new[k] = v
+			}
+		}
+		return types.NewRefValMap(types.DefaultTypeAdapter, new)
+
+	default:
+		// This should never happen since non-iterator
+		// types will have been returned in the preamble.
+		return val
+	}
+}
+
+// hasEmpty returns whether val is a map or a list that has any zero-sized
+// map or list elements recursively. Zero sized strings are not considered
+// to be empty.
+func hasEmpty(val iterator) bool {
+	it := val.Iterator()
+	switch val := val.(type) {
+	case traits.Lister:
+		for it.HasNext() == types.True {
+			elem := it.Next()
+			iter, ok := elem.(iterator)
+			if !ok {
+				continue
+			}
+			if iter.Size() == types.IntZero || hasEmpty(iter) {
+				return true
+			}
+		}
+	case traits.Mapper:
+		for it.HasNext() == types.True {
+			elem := val.Get(it.Next())
+			iter, ok := elem.(iterator)
+			if !ok {
+				continue
+			}
+			if iter.Size() == types.IntZero || hasEmpty(iter) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// iterator is the common interface for lists and maps required for dropEmpty.
+type iterator interface {
+	ref.Val
+	traits.Iterable
+	traits.Sizer
+}
+
+func dropFields(obj, fields ref.Val) ref.Val {
+	switch fields := fields.(type) {
+	case types.String:
+		return dropFieldPath(obj, fields)
+	case traits.Lister:
+		it := fields.Iterator()
+		for it.HasNext() == types.True {
+			obj = dropFieldPath(obj, it.Next().ConvertToType(types.StringType).(types.String))
+			if types.IsError(obj) {
+				return obj
+			}
+		}
+		return obj
+	}
+	return types.NewErr("invalid parameter type for drop: %v", fields.Type())
+}
+
+func dropFieldPath(arg ref.Val, path types.String) ref.Val {
+	segs, err := parsePath(string(path))
+	if err != nil {
+		return types.NewErr("invalid parameter path for drop: %s: %v", path, err)
+	}
+	val, _ := pathDelete(arg, segs)
+	return val
+}
+
+func keepFields(obj, fields ref.Val) ref.Val {
+	var paths []types.String
+	switch fields := fields.(type) {
+	case types.String:
+		paths = []types.String{fields}
+	case traits.Lister:
+		it := fields.Iterator()
+		for it.HasNext() == types.True {
+			elem := it.Next()
+			p, ok := elem.ConvertToType(types.StringType).(types.String)
+			if !ok {
+				return types.NewErr("invalid parameter type for keep: %v", elem.Type())
+			}
+			paths = append(paths, p)
+		}
+	default:
+		return types.NewErr("invalid parameter type for keep: %v", fields.Type())
+	}
+	segs := make([][]pathSegment, len(paths))
+	for i, p := range paths {
+		var err error
+		segs[i], err = parsePath(string(p))
+		if err != nil {
+			return types.NewErr("invalid parameter path for keep: %s: %v", p, err)
+		}
+	}
+	val, _ := pathKeep(obj, segs)
+	return val
+}
+
+func flattenKeys(arg, sep ref.Val) ref.Val {
+	m, ok := arg.(traits.Mapper)
+	if !ok {
+		return types.NoSuchOverloadErr()
+	}
+	s, ok := sep.(types.String)
+	if !ok {
+		return types.ValOrErr(sep, "no such overload")
+	}
+	if s == "" {
+		return types.NewErr("invalid empty separator for flatten_keys")
+	}
+	flat := make(map[ref.Val]ref.Val)
+	it := m.Iterator()
+	for it.HasNext() == types.True {
+		k := it.Next()
+		key, ok := k.ConvertToType(types.StringType).(types.String)
+		if !ok {
+			return types.NewErr("invalid key type for flatten_keys: %v", k.Type())
+		}
+		err := flattenKeysInto(flat, escapeFlatKey(string(key), string(s)), m.Get(k), string(s))
+		if err != nil {
+			return err
+		}
+	}
+	return types.NewRefValMap(types.DefaultTypeAdapter, flat)
+}
+
+// flattenKeysInto adds the leaves of v to dst with keys prefixed by prefix.
+func flattenKeysInto(dst map[ref.Val]ref.Val, prefix string, v ref.Val, sep string) ref.Val {
+	switch obj := v.(type) {
+	case traits.Mapper:
+		if obj.Size() == types.IntZero {
+			break
+		}
+		it := obj.Iterator()
+		for it.HasNext() == types.True {
+			k := it.Next()
+			key, ok := k.ConvertToType(types.StringType).(types.String)
+			if !ok {
+				return types.NewErr("invalid key type for flatten_keys: %v", k.Type())
+			}
+			err := flattenKeysInto(dst, prefix+sep+escapeFlatKey(string(key), sep), obj.Get(k), sep)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case traits.Lister:
+		if obj.Size() == types.IntZero {
+			break
+		}
+		it := obj.Iterator()
+		for i := 0; it.HasNext() == types.True; i++ {
+			err := flattenKeysInto(dst, prefix+"["+strconv.Itoa(i)+"]", it.Next(), sep)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	dst[types.String(prefix)] = v
+	return nil
+}
+
+func unflattenKeys(arg, sep ref.Val) ref.Val {
+	m, ok := arg.(traits.Mapper)
+	if !ok {
+		return types.NoSuchOverloadErr()
+	}
+	s, ok := sep.(types.String)
+	if !ok {
+		return types.ValOrErr(sep, "no such overload")
+	}
+	if s == "" {
+		return types.NewErr("invalid empty separator for unflatten_keys")
+	}
+	root := &unflattenNode{}
+	keys := sortedKeys(m)
+	for _, k := range keys {
+		key, ok := k.(types.String)
+		if !ok {
+			return types.NewErr("invalid key type for unflatten_keys: %v", k.Type())
+		}
+		segs, err := parseFlatKey(string(key), string(s))
+		if err != nil {
+			return types.NewErr("invalid key for unflatten_keys: %s: %v", key, err)
+		}
+		err = root.set(segs, m.Get(k), len(keys))
+		if err != nil {
+			return types.NewErr("failed to unflatten key %s: %v", key, err)
+		}
+	}
+	if root.fields == nil {
+		return types.NewRefValMap(types.DefaultTypeAdapter, map[ref.Val]ref.Val{})
+	}
+	return root.value()
+}
+
+// unflattenNode is a node in a tree of values under construction by
+// unflatten_keys. Exactly one of leaf, fields or elems is used by a
+// non-nil node.
+type unflattenNode struct {
+	leaf   ref.Val
+	fields map[string]*unflattenNode
+	elems  []*unflattenNode
+}
+
+// set sets the value at path below n to v. List indexes in path must be
+// less than limit; this bounds the allocation for sparse lists.
+func (n *unflattenNode) set(path []pathSegment, v ref.Val, limit int) error {
+	if len(path) == 0 {
+		if n.leaf != nil || n.fields != nil || n.elems != nil {
+			return errors.New("conflicting keys")
+		}
+		n.leaf = v
+		return nil
+	}
+	if n.leaf != nil {
+		return errors.New("conflicting keys")
+	}
+	var child *unflattenNode
+	switch seg := path[0]; seg.kind {
+	case keySegment:
+		if n.elems != nil {
+			return errors.New("conflicting keys")
+		}
+		if n.fields == nil {
+			n.fields = make(map[string]*unflattenNode)
+		}
+		child = n.fields[seg.key]
+		if child == nil {
+			child = &unflattenNode{}
+			n.fields[seg.key] = child
+		}
+	case indexSegment:
+		if n.fields != nil {
+			return errors.New("conflicting keys")
+		}
+		if seg.index >= limit {
+			return fmt.Errorf("index out of range: %d", seg.index)
+		}
+		for len(n.elems) <= seg.index {
+			n.elems = append(n.elems, &unflattenNode{})
+		}
+		child = n.elems[seg.index]
+	}
+	return child.set(path[1:], v, limit)
+}
+
+// value returns the CEL value constructed from n.
+func (n *unflattenNode) value() ref.Val {
+	switch {
+	case n.leaf != nil:
+		return n.leaf
+	case n.elems != nil:
+		elems := make([]ref.Val, len(n.elems))
+		for i, e := range n.elems {
+			elems[i] = e.value()
+		}
+		return types.NewRefValList(types.DefaultTypeAdapter, elems)
+	case n.fields != nil:
+		fields := make(map[ref.Val]ref.Val, len(n.fields))
+		for k, f := range n.fields {
+			fields[types.String(k)] = f.value()
+		}
+		return types.NewRefValMap(types.DefaultTypeAdapter, fields)
+	default:
+		// Missing list elements are the only unset nodes.
+		return types.NullValue
+	}
+}
+
+func getPathOrDefault(args ...ref.Val) ref.Val {
+	if len(args) != 3 {
+		return types.NewErr("no such overload for get_path")
+	}
+	return getPath(args[0], args[1], args[2])
+}
+
+// getPath returns the value at path in arg. If the path does not exist
+// def is returned, or an error if def is nil.
+func getPath(arg, path, def ref.Val) ref.Val {
+	p, ok := path.(types.String)
+	if !ok {
+		return types.ValOrErr(path, "no such overload")
+	}
+	segs, err := parseSinglePath(p)
+	if err != nil {
+		return types.NewErr("invalid parameter path for get_path: %s: %v", p, err)
+	}
+	val, ok := pathGet(arg, segs)
+	if !ok {
+		if def == nil {
+			return types.NewErr("no such path: %s", p)
+		}
+		return def
+	}
+	return val
+}
+
+func setPath(args ...ref.Val) ref.Val {
+	if len(args) != 3 {
+		return types.NewErr("no such overload for set_path")
+	}
+	p, ok := args[1].(types.String)
+	if !ok {
+		return types.ValOrErr(args[1], "no such overload")
+	}
+	segs, err := parseSinglePath(p)
+	if err != nil {
+		return types.NewErr("invalid parameter path for set_path: %s: %v", p, err)
+	}
+	if len(segs) == 0 {
+		return types.NewErr("invalid parameter path for set_path: empty path")
+	}
+	val, err := pathSet(args[0], segs, args[2])
+	if err != nil {
+		return types.NewErr("failed to set path %s: %v", p, err)
+	}
+	return val
+}
+
+func renameFields(arg, renames ref.Val) ref.Val {
+	m, ok := renames.(traits.Mapper)
+	if !ok {
+		return types.ValOrErr(renames, "no such overload")
+	}
+	type rename struct {
+		from, to []pathSegment
+	}
+	var paths []rename
+	for _, k := range sortedKeys(m) {
+		from, ok := k.(types.String)
+		if !ok {
+			return types.NewErr("invalid parameter type for rename: %v", k.Type())
+		}
+		to, ok := m.Get(k).(types.String)
+		if !ok {
+			return types.NewErr("invalid parameter type for rename: %v", m.Get(k).Type())
+		}
+		var (
+			r   rename
+			err error
+		)
+		r.from, err = parseSinglePath(from)
+		if err != nil {
+			return types.NewErr("invalid parameter path for rename: %s: %v", from, err)
+		}
+		r.to, err = parseSinglePath(to)
+		if err != nil {
+			return types.NewErr("invalid parameter path for rename: %s: %v", to, err)
+		}
+		if len(r.from) == 0 || len(r.to) == 0 {
+			return types.NewErr("invalid parameter path for rename: empty path")
+		}
+		paths = append(paths, r)
+	}
+
+	renameAll := func(obj ref.Val) ref.Val {
+		// Resolve all the source paths before deleting any of them,
+		// and then delete from the last to the first so that removing
+		// a list element does not shift the elements addressed by the
+		// remaining paths.
+		vals := make([]ref.Val, len(paths))
+		var found [][]pathSegment
+		for i, p := range paths {
+			var (
+				from []pathSegment
+				ok   bool
+			)
+			from, vals[i], ok = pathResolve(obj, p.from)
+			if ok {
+				found = append(found, from)
+			}
+		}
+		sort.Slice(found, func(i, j int) bool {
+			return comparePaths(found[i], found[j]) > 0
+		})
+		for i, from := range found {
+			if i != 0 && comparePaths(from, found[i-1]) == 0 {
+				continue
+			}
+			obj, _ = pathDelete(obj, from)
+		}
+		for i, p := range paths {
+			if vals[i] == nil {
+				continue
+			}
+			var err error
+			obj, err = pathSet(obj, p.to, vals[i])
+			if err != nil {
+				return types.NewErr("failed to rename path: %v", err)
+			}
+		}
+		return obj
+	}
+
+	switch obj := arg.(type) {
+	case traits.Lister:
+		new := make([]ref.Val, 0, int(obj.Size().(types.Int)))
+		it := obj.Iterator()
+		for it.HasNext() == types.True {
+			elem := renameAll(it.Next())
+			if types.IsError(elem) {
+				return elem
+			}
+			new = append(new, elem)
+		}
+		return types.NewRefValList(types.DefaultTypeAdapter, new)
+	case traits.Mapper:
+		return renameAll(obj)
+	default:
+		return types.NoSuchOverloadErr()
+	}
+}
+
+// parseSinglePath returns the segments of a path that addresses a single
+// value.
+func parseSinglePath(path types.String) ([]pathSegment, error) {
+	segs, err := parsePath(string(path))
+	if err != nil {
+		return nil, err
+	}
+	return segs, checkSinglePath(segs)
+}
+
+func collateFields(arg, fields ref.Val) ref.Val {
+	switch fields := fields.(type) {
+	case types.String:
+		elems, err := collateFieldPath(arg, fields)
+		if err != nil {
+			return err
+		}
+		return types.NewRefValList(types.DefaultTypeAdapter, elems)
+	case traits.Lister:
+		var elems []ref.Val
+		it := fields.Iterator()
+		for it.HasNext() == types.True {
+			switch field := it.Next().(type) {
+			case types.String:
+				vals, err := collateFieldPath(arg, field)
+				if err != nil {
+					return err
+				}
+				elems = append(elems, vals...)
+			default:
+				return types.NewErr("invalid parameter type for collate fields: %v", field.Type())
+			}
+		}
+		return types.NewRefValList(types.DefaultTypeAdapter, elems)
+	}
+	return types.NewErr("invalid parameter type for collate: %v", fields.Type())
+}
+
+func collateFieldPath(arg ref.Val, path types.String) ([]ref.Val, ref.Val) {
+	segs, err := parsePath(string(path))
+	if err != nil {
+		return nil, types.NewErr("invalid parameter path for collate: %s: %v", path, err)
+	}
+	var collation []ref.Val
+	for _, v := range pathLookup(arg, segs) {
+		switch v := v.(type) {
+		case traits.Lister:
+			it := v.Iterator()
+			for it.HasNext() == types.True {
+				collation = append(collation, it.Next())
+			}
+		default:
+			collation = append(collation, v)
+		}
+	}
+	return collation, nil
+}
+
+func min(arg ref.Val) ref.Val {
+	return compare(arg, -1)
+}
+
+func max(arg ref.Val) ref.Val {
+	return compare(arg, 1)
+}
+
+func compare(arg ref.Val, cmp types.Int) ref.Val {
+	list, ok := arg.(traits.Lister)
+	if !ok {
+		return types.NoSuchOverloadErr()
+	}
+
+	type comparer interface {
+		ref.Val
+		traits.Comparer
+	}
+	var min comparer
+	it := list.Iterator()
+	for it.HasNext() == types.True {
+		elem, ok := it.Next().(comparer)
+		if !ok {
+			return types.NoSuchOverloadErr()
+		}
+		if min == nil || elem.Compare(min) == cmp {
+			min = elem
+		}
+	}
+	return min
+}
+
+func count(arg ref.Val) ref.Val {
+	list, ok := arg.(traits.Lister)
+	if !ok {
+		return types.NoSuchOverloadErr()
+	}
+	var n types.Int
+	it := list.Iterator()
+	for it.HasNext() == types.True {
+		if it.Next() != types.NullValue {
+			n++
+		}
+	}
+	return n
+}
+
+func sum(arg ref.Val) ref.Val {
+	list, ok := arg.(traits.Lister)
+	if !ok {
+		return types.NoSuchOverloadErr()
+	}
+
+	// Sum in the type of the elements if they all share a type
+	// that can be summed without loss, otherwise sum as doubles.
+	var (
+		acc  ref.Val = types.IntZero
+		kind ref.Type
+	)
+	it := list.Iterator()
+	for it.HasNext() == types.True {
+		elem := it.Next()
+		if elem == types.NullValue {
+			continue
+		}
+		switch elem.(type) {
+		case types.Int, types.Uint, types.Double, types.Duration:
+		default:
+			return types.NewErr("invalid aggregate value type: %s", elem.Type())
+		}
+		switch {
+		case kind == nil:
+			kind = elem.Type()
+			acc = elem
+			continue
+		case kind == elem.Type():
+			acc = acc.(traits.Adder).Add(elem)
+			if types.IsError(acc) {
+				return acc
+			}
+			continue
+		case kind == types.DurationType || elem.Type() == types.DurationType:
+			return types.NewErr("cannot sum durations and numbers")
+		}
+		kind = types.DoubleType
+		acc = acc.ConvertToType(types.DoubleType).(types.Double) + elem.ConvertToType(types.DoubleType).(types.Double)
+	}
+	return acc
+}
+
+func mean(arg ref.Val) ref.Val {
+	vals, dur, err := aggregateValues(arg, "mean")
+	if err != nil {
+		return err
+	}
+	var sum float64
+	for _, v := range vals {
+		sum += v
+	}
+	return aggregateResult(sum/float64(len(vals)), dur)
+}
+
+func median(arg ref.Val) ref.Val {
+	return percentile(arg, types.Double(50))
+}
+
+func percentile(arg, p ref.Val) ref.Val {
+	var pct float64
+	switch p := p.(type) {
+	case types.Double:
+		pct = float64(p)
+	case types.Int:
+		pct = float64(p)
+	default:
+		return types.ValOrErr(p, "no such overload")
+	}
+	if pct < 0 || pct > 100 || math.IsNaN(pct) {
+		return types.NewErr("invalid percentile: %v", pct)
+	}
+	vals, dur, err := aggregateValues(arg, "percentile")
+	if err != nil {
+		return err
+	}
+	sort.Float64s(vals)
+	pos := pct / 100 * float64(len(vals)-1)
+	lo := math.Floor(pos)
+	v := vals[int(lo)]
+	if frac := pos - lo; frac != 0 {
+		v += frac * (vals[int(lo)+1] - v)
+	}
+	return aggregateResult(v, dur)
+}
+
+func stddev(arg ref.Val) ref.Val {
+	vals, dur, err := aggregateValues(arg, "standard deviation")
+	if err != nil {
+		return err
+	}
+	var sum float64
+	for _, v := range vals {
+		sum += v
+	}
+	mean := sum / float64(len(vals))
+	var ss float64
+	for _, v := range vals {
+		ss += (v - mean) * (v - mean)
+	}
+	return aggregateResult(math.Sqrt(ss/float64(len(vals))), dur)
+}
+
+// aggregateValues returns the non-null elements of the list arg as float64
+// values and whether they are durations, in which case the values are in
+// nanoseconds. It is an error for the list to be empty, or to contain values
+// other than numbers or durations, or to mix numbers and durations.
+func aggregateValues(arg ref.Val, name string) (vals []float64, dur bool, err ref.Val) {
+	list, ok := arg.(traits.Lister)
+	if !ok {
+		return nil, false, types.NoSuchOverloadErr()
+	}
+	var nums int
+	it := list.Iterator()
+	for it.HasNext() == types.True {
+		switch elem := it.Next().(type) {
+		case types.Null:
+			continue
+		case types.Int:
+			vals = append(vals, float64(elem))
+			nums++
+		case types.Uint:
+			vals = append(vals, float64(elem))
+			nums++
+		case types.Double:
+			vals = append(vals, float64(elem))
+			nums++
+		case types.Duration:
+			vals = append(vals, float64(elem.Duration))
+		default:
+			return nil, false, types.NewErr("invalid aggregate value type: %s", elem.Type())
+		}
+	}
+	if len(vals) == 0 {
+		return nil, false, types.NewErr("%s of empty list", name)
+	}
+	if nums != 0 && nums != len(vals) {
+		return nil, false, types.NewErr("cannot aggregate durations and numbers")
+	}
+	return vals, nums == 0, nil
+}
+
+// aggregateResult returns v as a double, or as a duration in nanoseconds
+// if dur is true.
+func aggregateResult(v float64, dur bool) ref.Val {
+	if dur {
+		return types.Duration{Duration: time.Duration(math.Round(v))}
+	}
+	return types.Double(v)
+}
+
+func makeAs(eh parser.ExprHelper, target *expr.Expr, args []*expr.Expr) (*expr.Expr, *common.Error) {
+	ident := args[0]
+	if _, ok := ident.ExprKind.(*expr.Expr_IdentExpr); !ok {
+		return nil, &common.Error{Message: "argument is not an identifier"}
 	}
+	label := ident.GetIdentExpr().GetName()
+
+	fn := args[1]
+	target = eh.NewList(target) // Fold is a list comprehension, so fake this.
+	accuExpr := eh.Ident(parser.AccumulatorName)
+	init := eh.NewList() // Also for the result.
+	condition := eh.LiteralBool(true)
+	step := eh.GlobalCall(operators.Add, accuExpr, eh.NewList(fn))
+	fold := eh.Fold(label, target, parser.AccumulatorName, init, condition, step, accuExpr)
+	return eh.GlobalCall(operators.Index, fold, eh.LiteralInt(0)), nil
 }
 
-func (collectionsLib) ProgramOptions() []cel.ProgramOption {
-	return []cel.ProgramOption{
-		cel.Functions(
-			&functions.Overload{
-				Operator: "list_collate_string",
-				Binary:   collateFields,
-			},
-			&functions.Overload{
-				Operator: "list_collate_list_string",
-				Binary:   collateFields,
-			},
-			&functions.Overload{
-				Operator: "map_collate_string",
-				Binary:   collateFields,
-			},
-			&functions.Overload{
-				Operator: "map_collate_list_string",
-				Binary:   collateFields,
-			},
-		),
-		cel.Functions(
-			&functions.Overload{
-				Operator: "list_drop_string",
-				Binary:   dropFields,
-			},
-			&functions.Overload{
-				Operator: "list_drop_list_string",
-				Binary:   dropFields,
-			},
-			&functions.Overload{
-				Operator: "map_drop_string",
-				Binary:   dropFields,
-			},
-			&functions.Overload{
-				Operator: "map_drop_list_string",
-				Binary:   dropFields,
-			},
-		),
-		cel.Functions(
-			&functions.Overload{
-				Operator: "list_drop_empty",
-				Unary:    dropEmpty,
-			},
-			&functions.Overload{
-				Operator: "map_drop_empty",
-				Unary:    dropEmpty,
-			},
-		),
-		cel.Functions(
-			&functions.Overload{
-				Operator: "list_flatten",
-				Unary:    flatten,
-			},
-		),
-		cel.Functions(
-			&functions.Overload{
-				Operator: "min_list",
-				Unary:    min,
-			},
-			&functions.Overload{
-				Operator: "list_min",
-				Unary:    min,
-			},
-		),
-		cel.Functions(
-			&functions.Overload{
-				Operator: "max_list",
-				Unary:    max,
-			},
-			&functions.Overload{
-				Operator: "list_max",
-				Unary:    max,
-			},
-		),
-		cel.Functions(
-			&functions.Overload{
-				Operator: "map_with_map",
-				Binary:   withAll,
-			},
-			&functions.Overload{
-				Operator: "map_with_update_map",
-				Binary:   withUpdate,
-			},
-			&functions.Overload{
-				Operator: "map_with_replace_map",
-				Binary:   withReplace,
-			},
-		),
-		cel.Functions(
-			&functions.Overload{
-				Operator: "range_list_list",
-				Unary:    rangeIter,
-			},
-		),
-	}
+// bindIdent returns an expression that evaluates body with label bound to
+// the value of val, using the same approach as the as macro.
+func bindIdent(eh parser.ExprHelper, label string, val, body *expr.Expr) *expr.Expr {
+	accuExpr := eh.Ident(parser.AccumulatorName)
+	step := eh.GlobalCall(operators.Add, accuExpr, eh.NewList(body))
+	fold := eh.Fold(label, eh.NewList(val), parser.AccumulatorName, eh.NewList(), eh.LiteralBool(true), step, accuExpr)
+	return eh.GlobalCall(operators.Index, fold, eh.LiteralInt(0))
 }
 
-func flatten(arg ref.Val) ref.Val {
-	obj := arg
-	l, ok := obj.(traits.Lister)
+func distinct(arg ref.Val) ref.Val {
+	list, ok := arg.(traits.Lister)
 	if !ok {
-		return types.ValOrErr(obj, "no such overload")
+		return types.NoSuchOverloadErr()
 	}
-	dst := types.NewMutableList(types.DefaultTypeAdapter)
-	flattenParts(dst, l)
-	return dst.ToImmutableList()
+	var (
+		seen valueSet
+		new  []ref.Val
+	)
+	it := list.Iterator()
+	for it.HasNext() == types.True {
+		elem := it.Next()
+		if seen.add(elem) {
+			new = append(new, elem)
+		}
+	}
+	return types.NewRefValList(types.DefaultTypeAdapter, new)
 }
 
-func flattenParts(dst traits.MutableLister, val traits.Lister) {
-	it := val.Iterator()
-	for it.HasNext().Value().(bool) {
-		if _, ok := it.Next().(traits.Lister); !ok {
-			dst.Add(val)
-			return
+func union(a, b ref.Val) ref.Val {
+	l1, l2, err := listPair(a, b)
+	if err != nil {
+		return err
+	}
+	var (
+		seen valueSet
+		new  []ref.Val
+	)
+	for _, l := range []traits.Lister{l1, l2} {
+		it := l.Iterator()
+		for it.HasNext() == types.True {
+			elem := it.Next()
+			if seen.add(elem) {
+				new = append(new, elem)
+			}
 		}
 	}
-	it = val.Iterator()
+	return types.NewRefValList(types.DefaultTypeAdapter, new)
+}
+
+func intersect(a, b ref.Val) ref.Val {
+	l1, l2, err := listPair(a, b)
+	if err != nil {
+		return err
+	}
+	return types.NewRefValList(types.DefaultTypeAdapter, filterMembership(l1, setOf(l2), true))
+}
+
+func difference(a, b ref.Val) ref.Val {
+	l1, l2, err := listPair(a, b)
+	if err != nil {
+		return err
+	}
+	return types.NewRefValList(types.DefaultTypeAdapter, filterMembership(l1, setOf(l2), false))
+}
+
+func symmetricDifference(a, b ref.Val) ref.Val {
+	l1, l2, err := listPair(a, b)
+	if err != nil {
+		return err
+	}
+	new := filterMembership(l1, setOf(l2), false)
+	new = append(new, filterMembership(l2, setOf(l1), false)...)
+	return types.NewRefValList(types.DefaultTypeAdapter, new)
+}
+
+// listPair returns a and b as lists, or an error if either is not a list.
+func listPair(a, b ref.Val) (l1, l2 traits.Lister, err ref.Val) {
+	l1, ok := a.(traits.Lister)
+	if !ok {
+		return nil, nil, types.NoSuchOverloadErr()
+	}
+	l2, ok = b.(traits.Lister)
+	if !ok {
+		return nil, nil, types.ValOrErr(b, "no such overload")
+	}
+	return l1, l2, nil
+}
+
+// setOf returns a valueSet holding the elements of l.
+func setOf(l traits.Lister) *valueSet {
+	var s valueSet
+	it := l.Iterator()
 	for it.HasNext() == types.True {
-		flattenParts(dst, it.Next().(traits.Lister))
+		s.add(it.Next())
 	}
+	return &s
 }
 
-func withAll(dst, src ref.Val) ref.Val {
-	new, other, err := with(dst, src)
+// filterMembership returns the unique elements of l that are members of
+// set if member is true, or are not members of set if member is false.
+func filterMembership(l traits.Lister, set *valueSet, member bool) []ref.Val {
+	var (
+		seen valueSet
+		new  []ref.Val
+	)
+	it := l.Iterator()
+	for it.HasNext() == types.True {
+		elem := it.Next()
+		if set.has(elem) == member && seen.add(elem) {
+			new = append(new, elem)
+		}
+	}
+	return new
+}
+
+// Internal functions used by the distinct_by and distinct_by_last macros.
+const (
+	distinctByFirstFunc = "__distinct_by_first__"
+	distinctByLastFunc  = "__distinct_by_last__"
+)
+
+func makeDistinctBy(fn string) parser.MacroExpander {
+	return func(eh parser.ExprHelper, target *expr.Expr, args []*expr.Expr) (*expr.Expr, *common.Error) {
+		ident := args[0]
+		if _, ok := ident.ExprKind.(*expr.Expr_IdentExpr); !ok {
+			return nil, &common.Error{Message: "argument is not an identifier"}
+		}
+		label := ident.GetIdentExpr().GetName()
+
+		// Construct a list of [key, element] pairs for the
+		// distinct function to operate on.
+		key := args[1]
+		accuExpr := eh.Ident(parser.AccumulatorName)
+		init := eh.NewList()
+		condition := eh.LiteralBool(true)
+		step := eh.GlobalCall(operators.Add, accuExpr, eh.NewList(eh.NewList(key, ident)))
+		fold := eh.Fold(label, target, parser.AccumulatorName, init, condition, step, accuExpr)
+		return eh.GlobalCall(fn, fold), nil
+	}
+}
+
+func distinctByFirst(arg ref.Val) ref.Val {
+	pairs, err := keyValuePairs(arg)
 	if err != nil {
 		return err
 	}
-	for k, v := range other {
-		new[k] = v
+	var (
+		seen valueSet
+		new  []ref.Val
+	)
+	for _, p := range pairs {
+		if seen.add(p[0]) {
+			new = append(new, p[1])
+		}
 	}
-	return types.NewRefValMap(types.DefaultTypeAdapter, new)
+	return types.NewRefValList(types.DefaultTypeAdapter, new)
+}
+
+func distinctByLast(arg ref.Val) ref.Val {
+	pairs, err := keyValuePairs(arg)
+	if err != nil {
+		return err
+	}
+	var (
+		seen valueSet
+		new  []ref.Val
+	)
+	for i := len(pairs) - 1; i >= 0; i-- {
+		if seen.add(pairs[i][0]) {
+			new = append(new, pairs[i][1])
+		}
+	}
+	for i, j := 0, len(new)-1; i < j; i, j = i+1, j-1 {
+		new[i], new[j] = new[j], new[i]
+	}
+	return types.NewRefValList(types.DefaultTypeAdapter, new)
+}
+
+// keyValuePairs returns the elements of a list of two element lists as
+// a slice of pairs.
+func keyValuePairs(arg ref.Val) ([][2]ref.Val, ref.Val) {
+	list, ok := arg.(traits.Lister)
+	if !ok {
+		return nil, types.NoSuchOverloadErr()
+	}
+	var pairs [][2]ref.Val
+	it := list.Iterator()
+	for it.HasNext() == types.True {
+		p, ok := it.Next().(traits.Lister)
+		if !ok || p.Size() != types.Int(2) {
+			return nil, types.NewErr("invalid key value pair")
+		}
+		pairs = append(pairs, [2]ref.Val{p.Get(types.IntZero), p.Get(types.IntOne)})
+	}
+	return pairs, nil
+}
+
+// valueSet is a set of CEL values that uses CEL equality for membership.
+// The zero value is an empty set ready to use.
+type valueSet struct {
+	buckets map[string][]ref.Val
+}
+
+// has returns whether v is an element of the set.
+func (s *valueSet) has(v ref.Val) bool {
+	for _, e := range s.buckets[equalityKey(v)] {
+		if types.Equal(e, v) == types.True {
+			return true
+		}
+	}
+	return false
+}
+
+// add adds v to the set, returning whether v was not already an element.
+func (s *valueSet) add(v ref.Val) bool {
+	if s.has(v) {
+		return false
+	}
+	if s.buckets == nil {
+		s.buckets = make(map[string][]ref.Val)
+	}
+	k := equalityKey(v)
+	s.buckets[k] = append(s.buckets[k], v)
+	return true
+}
+
+// canonical returns the element of the set that is equal to v and true if
+// there is one. Otherwise it adds v to the set and returns v and false.
+func (s *valueSet) canonical(v ref.Val) (ref.Val, bool) {
+	for _, e := range s.buckets[equalityKey(v)] {
+		if types.Equal(e, v) == types.True {
+			return e, true
+		}
+	}
+	s.add(v)
+	return v, false
+}
+
+// equalityKey returns a string that is equal for values that are equal
+// under CEL equality. Unequal values may share a key, so values with the
+// same key must be compared with types.Equal.
+func equalityKey(v ref.Val) string {
+	switch v := v.(type) {
+	case types.Int:
+		return "n" + strconv.FormatFloat(float64(v), 'g', -1, 64)
+	case types.Uint:
+		return "n" + strconv.FormatFloat(float64(v), 'g', -1, 64)
+	case types.Double:
+		if v == 0 {
+			// Make -0 and 0 share a key.
+			v = 0
+		}
+		return "n" + strconv.FormatFloat(float64(v), 'g', -1, 64)
+	case types.String:
+		return "s" + string(v)
+	case types.Bytes:
+		return "b" + string(v)
+	case types.Bool:
+		return "t" + strconv.FormatBool(bool(v))
+	case types.Null:
+		return "null"
+	case types.Duration:
+		return "d" + v.Duration.String()
+	case types.Timestamp:
+		return "ts" + v.UTC().Format(time.RFC3339Nano)
+	case traits.Lister:
+		var buf strings.Builder
+		buf.WriteString("l[")
+		it := v.Iterator()
+		for it.HasNext() == types.True {
+			buf.WriteString(strconv.Quote(equalityKey(it.Next())))
+			buf.WriteByte(',')
+		}
+		buf.WriteByte(']')
+		return buf.String()
+	case traits.Mapper:
+		var elems []string
+		it := v.Iterator()
+		for it.HasNext() == types.True {
+			k := it.Next()
+			elems = append(elems, strconv.Quote(equalityKey(k))+":"+strconv.Quote(equalityKey(v.Get(k))))
+		}
+		sort.Strings(elems)
+		return "m{" + strings.Join(elems, ",") + "}"
+	default:
+		return v.Type().TypeName()
+	}
+}
+
+// reduceAccumulatorName is the name of the internal accumulator used by
+// the reduce macro.
+const reduceAccumulatorName = "__reduce__"
+
+func makeReduce(eh parser.ExprHelper, target *expr.Expr, args []*expr.Expr) (*expr.Expr, *common.Error) {
+	accu := args[0]
+	if _, ok := accu.ExprKind.(*expr.Expr_IdentExpr); !ok {
+		return nil, &common.Error{Message: "accumulator argument is not an identifier"}
+	}
+	accuLabel := accu.GetIdentExpr().GetName()
+	ident := args[1]
+	if _, ok := ident.ExprKind.(*expr.Expr_IdentExpr); !ok {
+		return nil, &common.Error{Message: "element argument is not an identifier"}
+	}
+	label := ident.GetIdentExpr().GetName()
+	if label == accuLabel {
+		return nil, &common.Error{Message: "accumulator and element identifiers must be different"}
+	}
+
+	// The accumulated value is held in a single element list. This
+	// is necessary since the interpreter treats a fold with an empty
+	// list accumulator as a list construction and mutates the list
+	// in place, which would be the case for an init of [].
+	//
+	// Each step binds the user's accumulator label to the current
+	// accumulated value using the same approach as the as macro.
+	current := func() *expr.Expr {
+		return eh.GlobalCall(operators.Index, eh.Ident(reduceAccumulatorName), eh.LiteralInt(0))
+	}
+	bindAccu := eh.Ident(parser.AccumulatorName)
+	bind := eh.Fold(accuLabel, eh.NewList(current()), parser.AccumulatorName, eh.NewList(), eh.LiteralBool(true),
+		eh.GlobalCall(operators.Add, bindAccu, eh.NewList(args[3])), bindAccu)
+	step := eh.NewList(eh.GlobalCall(operators.Index, bind, eh.LiteralInt(0)))
+
+	init := eh.NewList(args[2])
+	condition := eh.LiteralBool(true)
+	return eh.Fold(label, target, reduceAccumulatorName, init, condition, step, current()), nil
+}
+
+// Internal functions and labels used by the filter_entries, map_keys and
+// map_values macros.
+const (
+	entriesToMapFunc  = "__entries_to_map__"
+	entriesTargetName = "__entries_target__"
+	entriesKeyName    = "__entries_key__"
+)
+
+func makeFilterEntries(eh parser.ExprHelper, target *expr.Expr, args []*expr.Expr) (*expr.Expr, *common.Error) {
+	key := args[0]
+	if _, ok := key.ExprKind.(*expr.Expr_IdentExpr); !ok {
+		return nil, &common.Error{Message: "key argument is not an identifier"}
+	}
+	keyLabel := key.GetIdentExpr().GetName()
+	val := args[1]
+	if _, ok := val.ExprKind.(*expr.Expr_IdentExpr); !ok {
+		return nil, &common.Error{Message: "value argument is not an identifier"}
+	}
+	valLabel := val.GetIdentExpr().GetName()
+	if keyLabel == valLabel {
+		return nil, &common.Error{Message: "key and value identifiers must be different"}
+	}
+
+	// Construct a list of [key, value] pairs for the fields that
+	// satisfy the predicate and convert them into a map.
+	field := func() *expr.Expr {
+		return eh.GlobalCall(operators.Index, eh.Ident(entriesTargetName), eh.Ident(keyLabel))
+	}
+	accuExpr := eh.Ident(parser.AccumulatorName)
+	init := eh.NewList()
+	condition := eh.LiteralBool(true)
+	step := eh.GlobalCall(operators.Conditional,
+		bindIdent(eh, valLabel, field(), args[2]),
+		eh.GlobalCall(operators.Add, accuExpr, eh.NewList(entryPair(eh, eh.Ident(keyLabel), field()))),
+		eh.Ident(parser.AccumulatorName),
+	)
+	fold := eh.Fold(keyLabel, eh.Ident(entriesTargetName), parser.AccumulatorName, init, condition, step, eh.Ident(parser.AccumulatorName))
+	return bindIdent(eh, entriesTargetName, target, eh.GlobalCall(entriesToMapFunc, fold)), nil
+}
+
+func makeMapKeys(eh parser.ExprHelper, target *expr.Expr, args []*expr.Expr) (*expr.Expr, *common.Error) {
+	key := args[0]
+	if _, ok := key.ExprKind.(*expr.Expr_IdentExpr); !ok {
+		return nil, &common.Error{Message: "argument is not an identifier"}
+	}
+	label := key.GetIdentExpr().GetName()
+
+	// Construct a list of [new key, value] pairs and convert
+	// them into a map.
+	field := eh.GlobalCall(operators.Index, eh.Ident(entriesTargetName), eh.Ident(label))
+	accuExpr := eh.Ident(parser.AccumulatorName)
+	init := eh.NewList()
+	condition := eh.LiteralBool(true)
+	step := eh.GlobalCall(operators.Add, accuExpr, eh.NewList(entryPair(eh, args[1], field)))
+	fold := eh.Fold(label, eh.Ident(entriesTargetName), parser.AccumulatorName, init, condition, step, eh.Ident(parser.AccumulatorName))
+	return bindIdent(eh, entriesTargetName, target, eh.GlobalCall(entriesToMapFunc, fold)), nil
+}
+
+func makeMapValues(eh parser.ExprHelper, target *expr.Expr, args []*expr.Expr) (*expr.Expr, *common.Error) {
+	val := args[0]
+	if _, ok := val.ExprKind.(*expr.Expr_IdentExpr); !ok {
+		return nil, &common.Error{Message: "argument is not an identifier"}
+	}
+	label := val.GetIdentExpr().GetName()
+
+	// Construct a list of [key, new value] pairs and convert
+	// them into a map.
+	field := eh.GlobalCall(operators.Index, eh.Ident(entriesTargetName), eh.Ident(entriesKeyName))
+	accuExpr := eh.Ident(parser.AccumulatorName)
+	init := eh.NewList()
+	condition := eh.LiteralBool(true)
+	step := eh.GlobalCall(operators.Add, accuExpr, eh.NewList(entryPair(eh, eh.Ident(entriesKeyName), bindIdent(eh, label, field, args[1]))))
+	fold := eh.Fold(entriesKeyName, eh.Ident(entriesTargetName), parser.AccumulatorName, init, condition, step, eh.Ident(parser.AccumulatorName))
+	return bindIdent(eh, entriesTargetName, target, eh.GlobalCall(entriesToMapFunc, fold)), nil
 }
 
-func withUpdate(dst, src ref.Val) ref.Val {
-	new, other, err := with(dst, src)
-	if err != nil {
-		return err
-	}
-	for k, v := range other {
-		if _, ok := new[k]; ok {
-			continue
-		}
-		new[k] = v
-	}
-	return types.NewRefValMap(types.DefaultTypeAdapter, new)
+// entryPair returns a [key, value] list expression. The elements are
+// converted to dyn so that the checker does not need to unify the key
+// and value types.
+func entryPair(eh parser.ExprHelper, key, val *expr.Expr) *expr.Expr {
+	return eh.NewList(eh.GlobalCall(overloads.TypeConvertDyn, key), eh.GlobalCall(overloads.TypeConvertDyn, val))
 }
 
-func withReplace(dst, src ref.Val) ref.Val {
-	new, other, err := with(dst, src)
+// entriesToMap returns a map constructed from a list of [key, value] pairs.
+// It is an error for a key to appear more than once.
+func entriesToMap(arg ref.Val) ref.Val {
+	pairs, err := keyValuePairs(arg)
 	if err != nil {
 		return err
 	}
-	for k, v := range other {
-		if _, ok := new[k]; !ok {
-			continue
+	var seen valueSet
+	new := make(map[ref.Val]ref.Val, len(pairs))
+	for _, p := range pairs {
+		if _, dup := seen.canonical(p[0]); dup {
+			return types.NewErr("duplicate key: %v", p[0])
 		}
-		new[k] = v
+		new[p[0]] = p[1]
 	}
 	return types.NewRefValMap(types.DefaultTypeAdapter, new)
 }
 
-var refValMap = reflect.TypeOf(map[ref.Val]ref.Val(nil))
-
-func with(dst, src ref.Val) (res, other map[ref.Val]ref.Val, maybe ref.Val) {
-	obj, ok := dst.(traits.Mapper)
-	if !ok {
-		return nil, nil, types.ValOrErr(obj, "no such overload")
-	}
-	val, ok := src.(traits.Mapper)
+func mapKeys(arg ref.Val) ref.Val {
+	obj, ok := arg.(traits.Mapper)
 	if !ok {
-		return nil, nil, types.ValOrErr(src, "unsupported src type")
+		return types.NoSuchOverloadErr()
 	}
+	return types.NewRefValList(types.DefaultTypeAdapter, sortedKeys(obj))
+}
 
-	new := make(map[ref.Val]ref.Val)
-	m, err := obj.ConvertToNative(refValMap)
-	if err != nil {
-		return nil, nil, types.NewErr("unable to convert dst to native: %v", err)
-	}
-	for k, v := range m.(map[ref.Val]ref.Val) {
-		new[k] = v
+func mapValues(arg ref.Val) ref.Val {
+	obj, ok := arg.(traits.Mapper)
+	if !ok {
+		return types.NoSuchOverloadErr()
 	}
-	m, err = val.ConvertToNative(refValMap)
-	if err != nil {
-		return nil, nil, types.NewErr("unable to convert src to native: %v", err)
+	keys := sortedKeys(obj)
+	vals := make([]ref.Val, len(keys))
+	for i, k := range keys {
+		vals[i] = obj.Get(k)
 	}
-	return new, m.(map[ref.Val]ref.Val), nil
+	return types.NewRefValList(types.DefaultTypeAdapter, vals)
 }
 
-// TODO: Make this configurable to allow map, list and string emptiness and null.
-func dropEmpty(val ref.Val) ref.Val {
-	obj, ok := val.(iterator)
-	if !ok || !hasEmpty(obj) {
-		return val
+func mapEntries(arg ref.Val) ref.Val {
+	obj, ok := arg.(traits.Mapper)
+	if !ok {
+		return types.NoSuchOverloadErr()
 	}
-
-	switch obj := val.(type) {
-	case traits.Lister:
-		new := make([]ref.Val, 0, obj.Size().Value().(int64))
-		it := obj.Iterator()
-		for it.HasNext() == types.True {
-			elem := it.Next()
-			switch val := elem.(type) {
-			case iterator:
-				if val.Size() != types.IntZero {
-					res := dropEmpty(val)
-					if v, ok := res.(traits.Sizer); ok {
-						if v.Size() != types.IntZero {
-							new = append(new, res)
-						}
-					} else {
-						new = append(new, res)
-					}
-				}
-			default:
-				new = append(new, val)
-			}
-		}
-		return types.NewRefValList(types.DefaultTypeAdapter, new)
-
-	case traits.Mapper:
-		new := make(map[ref.Val]ref.Val)
-		m, err := obj.ConvertToNative(refValMap)
-		if err != nil {
-			return types.NewErr("unable to convert map to native: %v", err)
-		}
-		for k, v := range m.(map[ref.Val]ref.Val) {
-			switch val := v.(type) {
-			case iterator:
-				if val.Size() != types.IntZero {
-					res := dropEmpty(v)
-					if v, ok := res.(traits.Sizer); ok {
-						if v.Size() != types.IntZero {
-							new[k] = res
-						}
-					} else {
-						new[k] = res
-					}
-				}
-			default:
-				new[k] = v
-			}
-		}
-		return types.NewRefValMap(types.DefaultTypeAdapter, new)
-
-	default:
-		// This should never happen since non-iterator
-		// types will have been returned in the preamble.
-		return val
+	keys := sortedKeys(obj)
+	entries := make([]ref.Val, len(keys))
+	for i, k := range keys {
+		entries[i] = types.NewRefValMap(types.DefaultTypeAdapter, map[ref.Val]ref.Val{
+			types.String("key"):   k,
+			types.String("value"): obj.Get(k),
+		})
 	}
+	return types.NewRefValList(types.DefaultTypeAdapter, entries)
 }
 
-// hasEmpty returns whether val is a map or a list that has any zero-sized
-// map or list elements recursively. Zero sized strings are not considered
-// to be empty.
-func hasEmpty(val iterator) bool {
-	it := val.Iterator()
-	switch val := val.(type) {
-	case traits.Lister:
-		for it.HasNext() == types.True {
-			elem := it.Next()
-			iter, ok := elem.(iterator)
+func fromEntries(arg ref.Val) ref.Val {
+	list, ok := arg.(traits.Lister)
+	if !ok {
+		return types.NoSuchOverloadErr()
+	}
+	var seen valueSet
+	new := make(map[ref.Val]ref.Val)
+	it := list.Iterator()
+	for it.HasNext() == types.True {
+		switch e := it.Next().(type) {
+		case traits.Mapper:
+			k, ok := e.Find(types.String("key"))
 			if !ok {
-				continue
+				return types.NewErr("entry missing key")
 			}
-			if iter.Size() == types.IntZero || hasEmpty(iter) {
-				return true
-			}
-		}
-	case traits.Mapper:
-		for it.HasNext() == types.True {
-			elem := val.Get(it.Next())
-			iter, ok := elem.(iterator)
+			v, ok := e.Find(types.String("value"))
 			if !ok {
-				continue
+				return types.NewErr("entry missing value")
 			}
-			if iter.Size() == types.IntZero || hasEmpty(iter) {
-				return true
+			k, _ = seen.canonical(k)
+			new[k] = v
+		case traits.Lister:
+			if e.Size() != types.Int(2) {
+				return types.NewErr("invalid key value pair")
 			}
+			k, _ := seen.canonical(e.Get(types.IntZero))
+			new[k] = e.Get(types.IntOne)
+		default:
+			return types.NewErr("invalid entry type: %s", e.Type())
 		}
 	}
-	return false
+	return types.NewRefValMap(types.DefaultTypeAdapter, new)
 }
 
-// iterator is the common interface for lists and maps required for dropEmpty.
-type iterator interface {
-	ref.Val
-	traits.Iterable
-	traits.Sizer
-}
+// toMapFunc is the internal function used by the index_by and to_map macros.
+const toMapFunc = "__to_map__"
 
-func dropFields(obj, fields ref.Val) ref.Val {
-	switch fields := fields.(type) {
-	case types.String:
-		return dropFieldPath(obj, fields)
-	case traits.Lister:
-		it := fields.Iterator()
-		for it.HasNext() == types.True {
-			obj = dropFieldPath(obj, it.Next().ConvertToType(types.StringType).(types.String))
-		}
-		return obj
+func makeIndexBy(eh parser.ExprHelper, target *expr.Expr, args []*expr.Expr) (*expr.Expr, *common.Error) {
+	var policy *expr.Expr
+	if len(args) == 3 {
+		policy = args[2]
 	}
-	return types.NewErr("invalid parameter type for drop: %v", fields.Type())
+	return expandToMap(eh, target, args[0], args[1], nil, policy)
 }
 
-func dropFieldPath(arg ref.Val, path types.String) (val ref.Val) {
-	defer func() {
-		switch err := recover().(type) {
-		case *types.Err:
-			val = err
-		}
-	}()
-	if !hasFieldPath(arg, path) {
-		return arg
+func makeToMap(eh parser.ExprHelper, target *expr.Expr, args []*expr.Expr) (*expr.Expr, *common.Error) {
+	var policy *expr.Expr
+	if len(args) == 4 {
+		policy = args[3]
 	}
+	return expandToMap(eh, target, args[0], args[1], args[2], policy)
+}
 
-	switch obj := arg.(type) {
-	case traits.Lister:
-		new := make([]ref.Val, 0, obj.Size().Value().(int64))
-		it := obj.Iterator()
-		for it.HasNext() == types.True {
-			elem := it.Next()
-			new = append(new, dropFieldPath(elem, path))
-		}
-		return types.NewRefValList(types.DefaultTypeAdapter, new)
+// expandToMap returns the expansion of the index_by and to_map macros. If val
+// is nil, the element is used as the value and if policy is nil the "last"
+// policy is used.
+func expandToMap(eh parser.ExprHelper, target, ident, key, val, policy *expr.Expr) (*expr.Expr, *common.Error) {
+	if _, ok := ident.ExprKind.(*expr.Expr_IdentExpr); !ok {
+		return nil, &common.Error{Message: "argument is not an identifier"}
+	}
+	label := ident.GetIdentExpr().GetName()
+	if val == nil {
+		val = eh.Ident(label)
+	}
+	if policy == nil {
+		policy = eh.LiteralString("last")
+	}
 
-	case traits.Mapper:
-		dotIdx := strings.Index(string(path), ".")
-		switch {
-		case dotIdx == 0, dotIdx == len(path)-1:
-			return types.NewErr("invalid parameter path for drop: %s", path)
+	// Construct a list of [key, value] pairs for the
+	// map construction function to operate on.
+	accuExpr := eh.Ident(parser.AccumulatorName)
+	init := eh.NewList()
+	condition := eh.LiteralBool(true)
+	step := eh.GlobalCall(operators.Add, accuExpr, eh.NewList(entryPair(eh, key, val)))
+	fold := eh.Fold(label, target, parser.AccumulatorName, init, condition, step, eh.Ident(parser.AccumulatorName))
+	return eh.GlobalCall(toMapFunc, fold, policy), nil
+}
 
-		case dotIdx < 0:
-			new := make(map[ref.Val]ref.Val)
-			m, err := obj.ConvertToNative(refValMap)
-			if err != nil {
-				return types.NewErr("unable to convert map to native: %v", err)
+// toMap returns a map constructed from a list of [key, value] pairs with
+// duplicate keys handled according to policy.
+func toMap(arg, policy ref.Val) ref.Val {
+	pol, ok := policy.(types.String)
+	if !ok {
+		return types.ValOrErr(pol, "no such overload for duplicate key policy: %s", policy.Type())
+	}
+	pairs, err := keyValuePairs(arg)
+	if err != nil {
+		return err
+	}
+	// Keys are compared using CEL equality, with the first of a
+	// set of equal keys used in the result.
+	var seen valueSet
+	new := make(map[ref.Val]ref.Val, len(pairs))
+	switch pol {
+	case "first":
+		for _, p := range pairs {
+			if k, dup := seen.canonical(p[0]); !dup {
+				new[k] = p[1]
 			}
-			for k, v := range m.(map[ref.Val]ref.Val) {
-				if k.Equal(path) == types.False {
-					new[k] = v
-				}
+		}
+	case "last":
+		for _, p := range pairs {
+			k, _ := seen.canonical(p[0])
+			new[k] = p[1]
+		}
+	case "error":
+		for _, p := range pairs {
+			k, dup := seen.canonical(p[0])
+			if dup {
+				return types.NewErr("duplicate key: %v", p[0])
 			}
-			return types.NewRefValMap(types.DefaultTypeAdapter, new)
-
-		default:
-			new := make(map[ref.Val]ref.Val)
-			m, err := obj.ConvertToNative(refValMap)
-			if err != nil {
-				return types.NewErr("unable to convert map to native: %v", err)
-			}
-			head := path[:dotIdx]
-			tail := path[dotIdx+1:]
-			for k, v := range m.(map[ref.Val]ref.Val) {
-				if k.Equal(head) == types.True {
-					new[head] = dropFieldPath(v, tail)
-				} else {
-					new[k] = v
-				}
+			new[k] = p[1]
+		}
+	case "collect":
+		var keys []ref.Val
+		collected := make(map[ref.Val][]ref.Val)
+		for _, p := range pairs {
+			k, dup := seen.canonical(p[0])
+			if !dup {
+				keys = append(keys, k)
 			}
-			return types.NewRefValMap(types.DefaultTypeAdapter, new)
+			collected[k] = append(collected[k], p[1])
 		}
+		for _, k := range keys {
+			new[k] = types.NewRefValList(types.DefaultTypeAdapter, collected[k])
+		}
+	default:
+		return types.NewErr("invalid duplicate key policy: %s", pol)
+	}
+	return types.NewRefValMap(types.DefaultTypeAdapter, new)
+}
+
+// Internal functions and labels used by the join macro.
+const (
+	joinKeysFunc    = "__join_keys__"
+	joinMatchesFunc = "__join_matches__"
+	joinLeftName    = "__join_left__"
+	joinRightName   = "__join_right__"
+)
+
+func makeJoin(eh parser.ExprHelper, target *expr.Expr, args []*expr.Expr) (*expr.Expr, *common.Error) {
+	left := args[1]
+	if _, ok := left.ExprKind.(*expr.Expr_IdentExpr); !ok {
+		return nil, &common.Error{Message: "left argument is not an identifier"}
+	}
+	leftLabel := left.GetIdentExpr().GetName()
+	right := args[2]
+	if _, ok := right.ExprKind.(*expr.Expr_IdentExpr); !ok {
+		return nil, &common.Error{Message: "right argument is not an identifier"}
+	}
+	rightLabel := right.GetIdentExpr().GetName()
+	if leftLabel == rightLabel {
+		return nil, &common.Error{Message: "left and right identifiers must be different"}
+	}
+	kind := eh.LiteralString("inner")
+	if len(args) == 5 {
+		kind = args[4]
+	}
+
+	// collect returns a list of the values of fn evaluated for
+	// each element of the list bound to list with label bound
+	// to the element.
+	collect := func(label, list string, fn *expr.Expr) *expr.Expr {
+		accuExpr := eh.Ident(parser.AccumulatorName)
+		init := eh.NewList()
+		condition := eh.LiteralBool(true)
+		step := eh.GlobalCall(operators.Add, accuExpr, eh.NewList(fn))
+		return eh.Fold(label, eh.Ident(list), parser.AccumulatorName, init, condition, step, eh.Ident(parser.AccumulatorName))
+	}
 
+	var join *expr.Expr
+	pred := args[3]
+	if leftKey, rightKey, ok := joinKeyExprs(pred, leftLabel, rightLabel); ok {
+		// Construct lists of keys for the left and right
+		// elements and join by key lookup.
+		join = eh.GlobalCall(joinKeysFunc,
+			eh.Ident(joinLeftName), eh.Ident(joinRightName),
+			collect(leftLabel, joinLeftName, eh.GlobalCall(overloads.TypeConvertDyn, leftKey)),
+			collect(rightLabel, joinRightName, eh.GlobalCall(overloads.TypeConvertDyn, rightKey)),
+			kind,
+		)
+	} else {
+		// Construct a list of lists of predicate results for
+		// each pair of left and right elements.
+		join = eh.GlobalCall(joinMatchesFunc,
+			eh.Ident(joinLeftName), eh.Ident(joinRightName),
+			collect(leftLabel, joinLeftName, collect(rightLabel, joinRightName, pred)),
+			kind,
+		)
+	}
+	return bindIdent(eh, joinLeftName, target, bindIdent(eh, joinRightName, args[0], join)), nil
+}
+
+// joinKeyExprs returns the left and right key expressions of pred if it is
+// an equality between an expression that does not refer to right and an
+// expression that does not refer to left.
+func joinKeyExprs(pred *expr.Expr, left, right string) (leftKey, rightKey *expr.Expr, ok bool) {
+	call, ok := pred.ExprKind.(*expr.Expr_CallExpr)
+	if !ok || call.CallExpr.Function != operators.Equals || call.CallExpr.Target != nil || len(call.CallExpr.Args) != 2 {
+		return nil, nil, false
+	}
+	a, b := call.CallExpr.Args[0], call.CallExpr.Args[1]
+	aIdents := exprIdents(a, make(map[string]bool))
+	bIdents := exprIdents(b, make(map[string]bool))
+	switch {
+	case !aIdents[right] && !bIdents[left]:
+		return a, b, true
+	case !aIdents[left] && !bIdents[right]:
+		return b, a, true
 	default:
-		return obj
+		return nil, nil, false
 	}
 }
 
-func hasFieldPath(arg ref.Val, path types.String) bool {
-	switch obj := arg.(type) {
-	case traits.Lister:
-		it := obj.Iterator()
-		for it.HasNext() == types.True {
-			if hasFieldPath(it.Next(), path) {
-				return true
-			}
+// exprIdents adds the names of all identifiers referred to in e to idents
+// and returns it.
+func exprIdents(e *expr.Expr, idents map[string]bool) map[string]bool {
+	if e == nil {
+		return idents
+	}
+	switch e := e.ExprKind.(type) {
+	case *expr.Expr_IdentExpr:
+		idents[e.IdentExpr.Name] = true
+	case *expr.Expr_SelectExpr:
+		exprIdents(e.SelectExpr.Operand, idents)
+	case *expr.Expr_CallExpr:
+		exprIdents(e.CallExpr.Target, idents)
+		for _, a := range e.CallExpr.Args {
+			exprIdents(a, idents)
 		}
-		return false
+	case *expr.Expr_ListExpr:
+		for _, el := range e.ListExpr.Elements {
+			exprIdents(el, idents)
+		}
+	case *expr.Expr_StructExpr:
+		for _, ent := range e.StructExpr.Entries {
+			exprIdents(ent.GetMapKey(), idents)
+			exprIdents(ent.Value, idents)
+		}
+	case *expr.Expr_ComprehensionExpr:
+		c := e.ComprehensionExpr
+		for _, sub := range []*expr.Expr{c.IterRange, c.AccuInit, c.LoopCondition, c.LoopStep, c.Result} {
+			exprIdents(sub, idents)
+		}
+	}
+	return idents
+}
 
-	case traits.Mapper:
-		dotIdx := strings.Index(string(path), ".")
-		switch {
-		case dotIdx == 0, dotIdx == len(path)-1:
-			panic(types.NewErr("invalid parameter path for drop: %s", path))
+// joinKeys returns the join of the left and right lists where elements
+// are matched by equality of their corresponding keys.
+func joinKeys(args ...ref.Val) ref.Val {
+	if len(args) != 5 {
+		return types.NoSuchOverloadErr()
+	}
+	left, ok := args[0].(traits.Lister)
+	if !ok {
+		return types.ValOrErr(args[0], "no such overload")
+	}
+	right, ok := args[1].(traits.Lister)
+	if !ok {
+		return types.ValOrErr(args[1], "no such overload")
+	}
+	leftKeys, ok := args[2].(traits.Lister)
+	if !ok {
+		return types.ValOrErr(args[2], "no such overload")
+	}
+	rightKeys, ok := args[3].(traits.Lister)
+	if !ok {
+		return types.ValOrErr(args[3], "no such overload")
+	}
 
-		case dotIdx < 0:
-			m, err := obj.ConvertToNative(refValMap)
-			if err != nil {
-				panic(types.NewErr("unable to convert map to native: %v", err))
-			}
-			for k := range m.(map[ref.Val]ref.Val) {
-				if k.Equal(path) == types.True {
-					return true
-				}
+	// Index the right elements by key.
+	index := make(map[string][]int)
+	var keys []ref.Val
+	it := rightKeys.Iterator()
+	for i := 0; it.HasNext() == types.True; i++ {
+		k := it.Next()
+		h := equalityKey(k)
+		index[h] = append(index[h], i)
+		keys = append(keys, k)
+	}
+	matches := func(k ref.Val) []int {
+		var idx []int
+		for _, j := range index[equalityKey(k)] {
+			if types.Equal(keys[j], k) == types.True {
+				idx = append(idx, j)
 			}
-			return false
+		}
+		return idx
+	}
+	return join(left, right, leftKeys, matches, args[4])
+}
 
-		default:
-			m, err := obj.ConvertToNative(refValMap)
-			if err != nil {
-				panic(types.NewErr("unable to convert map to native: %v", err))
-			}
-			head := path[:dotIdx]
-			tail := path[dotIdx+1:]
-			for k, v := range m.(map[ref.Val]ref.Val) {
-				if k.Equal(head) == types.True {
-					return hasFieldPath(v, tail)
-				}
+// joinMatches returns the join of the left and right lists where elements
+// are matched according to a list of lists of predicate results.
+func joinMatches(args ...ref.Val) ref.Val {
+	if len(args) != 4 {
+		return types.NoSuchOverloadErr()
+	}
+	left, ok := args[0].(traits.Lister)
+	if !ok {
+		return types.ValOrErr(args[0], "no such overload")
+	}
+	right, ok := args[1].(traits.Lister)
+	if !ok {
+		return types.ValOrErr(args[1], "no such overload")
+	}
+	preds, ok := args[2].(traits.Lister)
+	if !ok {
+		return types.ValOrErr(args[2], "no such overload")
+	}
+	matches := func(p ref.Val) []int {
+		var idx []int
+		row, ok := p.(traits.Lister)
+		if !ok {
+			return nil
+		}
+		it := row.Iterator()
+		for j := 0; it.HasNext() == types.True; j++ {
+			if it.Next() == types.True {
+				idx = append(idx, j)
 			}
-			return false
 		}
+		return idx
+	}
+	return join(left, right, preds, matches, args[3])
+}
 
+// join returns the join of the left and right lists. The matches function
+// is called with the element of match corresponding to each left element
+// to obtain the indexes of matching right elements.
+func join(left, right, match traits.Lister, matches func(ref.Val) []int, kind ref.Val) ref.Val {
+	k, ok := kind.(types.String)
+	if !ok {
+		return types.ValOrErr(kind, "no such overload for join kind: %s", kind.Type())
+	}
+	switch k {
+	case "inner", "left", "full":
 	default:
-		return false
+		return types.NewErr("invalid join kind: %s", k)
 	}
-}
 
-func collateFields(arg, fields ref.Val) (vals ref.Val) {
-	defer func() {
-		switch err := recover().(type) {
-		case *types.Err:
-			vals = err
+	var rightElems []traits.Mapper
+	it := right.Iterator()
+	for it.HasNext() == types.True {
+		r, ok := it.Next().(traits.Mapper)
+		if !ok {
+			return types.NewErr("join elements must be maps")
 		}
-	}()
-	switch fields := fields.(type) {
-	case types.String:
-		return types.NewRefValList(types.DefaultTypeAdapter, collateFieldPath(arg, fields))
-	case traits.Lister:
-		var elems []ref.Val
-		it := fields.Iterator()
-		for it.HasNext() == types.True {
-			switch field := it.Next().(type) {
-			case types.String:
-				elems = append(elems, collateFieldPath(arg, field.ConvertToType(types.StringType).(types.String))...)
-			default:
-				return types.NewErr("invalid parameter type for collate fields: %v", field.Type())
+		rightElems = append(rightElems, r)
+	}
+	var (
+		new     []ref.Val
+		matched = make([]bool, len(rightElems))
+	)
+	it = left.Iterator()
+	mt := match.Iterator()
+	for it.HasNext() == types.True {
+		l, ok := it.Next().(traits.Mapper)
+		if !ok {
+			return types.NewErr("join elements must be maps")
+		}
+		idx := matches(mt.Next())
+		if len(idx) == 0 && k != "inner" {
+			new = append(new, l)
+			continue
+		}
+		for _, j := range idx {
+			matched[j] = true
+			new = append(new, mergeFields(l, rightElems[j]))
+		}
+	}
+	if k == "full" {
+		for j, r := range rightElems {
+			if !matched[j] {
+				new = append(new, r)
 			}
 		}
-		return types.NewRefValList(types.DefaultTypeAdapter, elems)
 	}
-	return types.NewErr("invalid parameter type for collate: %v", fields.Type())
+	return types.NewRefValList(types.DefaultTypeAdapter, new)
 }
 
-func collateFieldPath(arg ref.Val, path types.String) []ref.Val {
-	var collation []ref.Val
-	switch obj := arg.(type) {
-	case traits.Lister:
-		it := obj.Iterator()
+// mergeFields returns a map with the fields of dst and src, with fields
+// from src replacing fields with the same key in dst.
+func mergeFields(dst, src traits.Mapper) ref.Val {
+	new := make(map[ref.Val]ref.Val)
+	for _, m := range []traits.Mapper{dst, src} {
+		it := m.Iterator()
 		for it.HasNext() == types.True {
-			elem := it.Next()
-			collation = append(collation, collateFieldPath(elem, path)...)
+			k := it.Next()
+			new[k] = m.Get(k)
 		}
-		return collation
-
-	case traits.Mapper:
-		dotIdx := strings.Index(string(path), ".")
-		switch {
-		case dotIdx == 0, dotIdx == len(path)-1:
-			panic(types.NewErr("invalid parameter path for drop: %s", path))
+	}
+	return types.NewRefValMap(types.DefaultTypeAdapter, new)
+}
 
-		case dotIdx < 0:
-			m, err := obj.ConvertToNative(refValMap)
-			if err != nil {
-				panic(types.NewErr("unable to convert map to native: %v", err))
-			}
-			for k, v := range m.(map[ref.Val]ref.Val) {
-				if k.Equal(path) == types.True {
-					switch v := v.(type) {
-					case traits.Lister:
-						it := v.Iterator()
-						for it.HasNext() == types.True {
-							collation = append(collation, it.Next())
-						}
-					default:
-						collation = append(collation, v)
-					}
-				}
-			}
+// Internal functions and labels used by the walk and walk_nodes macros.
+const (
+	walkLeavesFunc  = "__walk_leaves__"
+	walkNodesFunc   = "__walk_nodes__"
+	walkRebuildFunc = "__walk_rebuild__"
+	walkTargetName  = "__walk_target__"
+	walkElemName    = "__walk_elem__"
+)
 
-		default:
-			m, err := obj.ConvertToNative(refValMap)
-			if err != nil {
-				panic(types.NewErr("unable to convert map to native: %v", err))
-			}
-			head := path[:dotIdx]
-			tail := path[dotIdx+1:]
-			for k, v := range m.(map[ref.Val]ref.Val) {
-				if k.Equal(head) == types.True {
-					collation = append(collation, collateFieldPath(v, tail)...)
-				}
-			}
+func makeWalk(collect string) parser.MacroExpander {
+	return func(eh parser.ExprHelper, target *expr.Expr, args []*expr.Expr) (*expr.Expr, *common.Error) {
+		path := args[0]
+		if _, ok := path.ExprKind.(*expr.Expr_IdentExpr); !ok {
+			return nil, &common.Error{Message: "path argument is not an identifier"}
+		}
+		pathLabel := path.GetIdentExpr().GetName()
+		val := args[1]
+		if _, ok := val.ExprKind.(*expr.Expr_IdentExpr); !ok {
+			return nil, &common.Error{Message: "value argument is not an identifier"}
+		}
+		valLabel := val.GetIdentExpr().GetName()
+		if pathLabel == valLabel {
+			return nil, &common.Error{Message: "path and value identifiers must be different"}
 		}
 
-	default:
-		if path == "" {
-			collation = []ref.Val{obj}
+		// Construct a list of [path, result] pairs by mapping over
+		// the [path, value] pairs collected from the target, and then
+		// use these to rebuild the target. The target is bound to an
+		// identifier so that it is only evaluated once.
+		elem := func(i int64) *expr.Expr {
+			return eh.GlobalCall(operators.Index, eh.Ident(walkElemName), eh.LiteralInt(i))
 		}
+		fn := bindIdent(eh, pathLabel, elem(0), bindIdent(eh, valLabel, elem(1), args[2]))
+		accuExpr := eh.Ident(parser.AccumulatorName)
+		init := eh.NewList()
+		condition := eh.LiteralBool(true)
+		step := eh.GlobalCall(operators.Add, accuExpr, eh.NewList(eh.NewList(elem(0), fn)))
+		results := eh.Fold(walkElemName, eh.GlobalCall(collect, eh.Ident(walkTargetName)), parser.AccumulatorName, init, condition, step, accuExpr)
+		return bindIdent(eh, walkTargetName, target, eh.GlobalCall(walkRebuildFunc, eh.Ident(walkTargetName), results)), nil
 	}
-
-	return collation
-}
-
-func min(arg ref.Val) ref.Val {
-	return compare(arg, -1)
 }
 
-func max(arg ref.Val) ref.Val {
-	return compare(arg, 1)
+// walkValues returns a list of [path, value] pairs for the values in v in
+// depth-first order. If nodes is true, maps and lists below v are included.
+func walkValues(v ref.Val, nodes bool) ref.Val {
+	var pairs []ref.Val
+	var walk func(v ref.Val, path []pathSegment)
+	walk = func(v ref.Val, path []pathSegment) {
+		_, container := v.(iterator)
+		if !container || (nodes && len(path) != 0) {
+			pairs = append(pairs, types.NewRefValList(types.DefaultTypeAdapter, []ref.Val{types.String(joinPath(path)), v}))
+		}
+		switch obj := v.(type) {
+		case traits.Mapper:
+			for _, k := range sortedKeys(obj) {
+				walk(obj.Get(k), appendKey(path, k))
+			}
+		case traits.Lister:
+			it := obj.Iterator()
+			for i := 0; it.HasNext() == types.True; i++ {
+				walk(it.Next(), append(path[:len(path):len(path)], pathSegment{kind: indexSegment, index: i}))
+			}
+		}
+	}
+	walk(v, nil)
+	return types.NewRefValList(types.DefaultTypeAdapter, pairs)
 }
 
-func compare(arg ref.Val, cmp types.Int) ref.Val {
-	list, ok := arg.(traits.Lister)
-	if !ok {
-		return types.NoSuchOverloadErr()
+// walkRebuild returns target with the values at the paths in the [path, value]
+// pairs of results replaced with the corresponding value. Null values delete
+// the value at the path. Results for paths within a replaced or deleted value
+// are ignored. The results must be in the order produced by walkValues for
+// target; the target is traversed in the same order so that map keys keep
+// their original values.
+func walkRebuild(target, results ref.Val) ref.Val {
+	pairs, err := keyValuePairs(results)
+	if err != nil {
+		return err
 	}
-
-	type comparer interface {
-		ref.Val
-		traits.Comparer
+	for _, p := range pairs {
+		if _, ok := p[0].(types.String); !ok {
+			return types.NewErr("invalid walk path type: %v", p[0].Type())
+		}
 	}
-	var min comparer
-	it := list.Iterator()
-	for it.HasNext() == types.True {
-		elem, ok := it.Next().(comparer)
-		if !ok {
-			return types.NoSuchOverloadErr()
+	var (
+		pos     int
+		rebuild func(v ref.Val, path []pathSegment) (ref.Val, bool)
+	)
+	children := func(v ref.Val, path []pathSegment) ref.Val {
+		switch obj := v.(type) {
+		case traits.Mapper:
+			new := make(map[ref.Val]ref.Val)
+			for _, k := range sortedKeys(obj) {
+				if c, ok := rebuild(obj.Get(k), appendKey(path, k)); ok {
+					new[k] = c
+				}
+			}
+			return types.NewRefValMap(types.DefaultTypeAdapter, new)
+		case traits.Lister:
+			var new []ref.Val
+			it := obj.Iterator()
+			for i := 0; it.HasNext() == types.True; i++ {
+				if c, ok := rebuild(it.Next(), append(path[:len(path):len(path)], pathSegment{kind: indexSegment, index: i})); ok {
+					new = append(new, c)
+				}
+			}
+			return types.NewRefValList(types.DefaultTypeAdapter, new)
+		default:
+			return v
 		}
-		if min == nil || elem.Compare(min) == cmp {
-			min = elem
+	}
+	// rebuild returns the rebuilt value of v at path and whether
+	// it is retained.
+	rebuild = func(v ref.Val, path []pathSegment) (ref.Val, bool) {
+		if pos < len(pairs) && string(pairs[pos][0].(types.String)) == joinPath(path) {
+			val := pairs[pos][1]
+			pos++
+			if val == types.NullValue || types.Equal(v, val) != types.True {
+				// Consume the results for the
+				// replaced value's descendants.
+				children(v, path)
+				return val, val != types.NullValue
+			}
 		}
+		return children(v, path), true
 	}
-	return min
-}
-
-func makeAs(eh parser.ExprHelper, target *expr.Expr, args []*expr.Expr) (*expr.Expr, *common.Error) {
-	ident := args[0]
-	if _, ok := ident.ExprKind.(*expr.Expr_IdentExpr); !ok {
-		return nil, &common.Error{Message: "argument is not an identifier"}
+	res, ok := rebuild(target, nil)
+	if !ok {
+		return types.NullValue
 	}
-	label := ident.GetIdentExpr().GetName()
-
-	fn := args[1]
-	target = eh.NewList(target) // Fold is a list comprehension, so fake this.
-	accuExpr := eh.Ident(parser.AccumulatorName)
-	init := eh.NewList() // Also for the result.
-	condition := eh.LiteralBool(true)
-	step := eh.GlobalCall(operators.Add, accuExpr, eh.NewList(fn))
-	fold := eh.Fold(label, target, parser.AccumulatorName, init, condition, step, accuExpr)
-	return eh.GlobalCall(operators.Index, fold, eh.LiteralInt(0)), nil
+	return res
 }
 
 func rangeIter(vals ref.Val) ref.Val {
@@ -949,3 +4213,375 @@ type iter struct {
 func (i iter) Len() int                   { return int(i.n) }
 func (iter) Get(i int) protoreflect.Value { return protoreflect.ValueOf(int64(i)) }
 func (iter) IsValid() bool                { return true }
+
+func product(arg ref.Val) ref.Val {
+	lists, err := listsOf(arg)
+	if err != nil {
+		return err
+	}
+	n := 1
+	sizes := make([]int, len(lists))
+	for i, l := range lists {
+		sizes[i] = int(l.Size().(types.Int))
+		if sizes[i] != 0 && n > math.MaxInt/sizes[i] {
+			return types.NewErr("product too large")
+		}
+		n *= sizes[i]
+	}
+	return newLazyList(n, func(i int) ref.Val {
+		elems := make([]ref.Val, len(lists))
+		for j := len(lists) - 1; j >= 0; j-- {
+			elems[j] = lists[j].Get(types.Int(i % sizes[j]))
+			i /= sizes[j]
+		}
+		return types.NewRefValList(types.DefaultTypeAdapter, elems)
+	})
+}
+
+// zipLists returns a list of lists holding the corresponding elements of the lists
+// in arg. If longest is true the length of the returned list is the length of
+// the longest list in arg with missing elements filled with null, otherwise it
+// is the length of the shortest list.
+func zipLists(arg ref.Val, longest bool) ref.Val {
+	lists, err := listsOf(arg)
+	if err != nil {
+		return err
+	}
+	var n int
+	sizes := make([]int, len(lists))
+	for i, l := range lists {
+		sizes[i] = int(l.Size().(types.Int))
+		if i == 0 || (longest && sizes[i] > n) || (!longest && sizes[i] < n) {
+			n = sizes[i]
+		}
+	}
+	return newLazyList(n, func(i int) ref.Val {
+		elems := make([]ref.Val, len(lists))
+		for j, l := range lists {
+			if i < sizes[j] {
+				elems[j] = l.Get(types.Int(i))
+			} else {
+				elems[j] = types.NullValue
+			}
+		}
+		return types.NewRefValList(types.DefaultTypeAdapter, elems)
+	})
+}
+
+func unzip(arg ref.Val) ref.Val {
+	lists, err := listsOf(arg)
+	if err != nil {
+		return err
+	}
+	if len(lists) == 0 {
+		return types.NewRefValList(types.DefaultTypeAdapter, nil)
+	}
+	n := lists[0].Size().(types.Int)
+	cols := make([][]ref.Val, n)
+	for _, l := range lists {
+		if l.Size() != n {
+			return types.NewErr("mismatched length in unzip call: %d != %d", l.Size(), n)
+		}
+		for j := range cols {
+			cols[j] = append(cols[j], l.Get(types.Int(j)))
+		}
+	}
+	new := make([]ref.Val, n)
+	for j, c := range cols {
+		new[j] = types.NewRefValList(types.DefaultTypeAdapter, c)
+	}
+	return types.NewRefValList(types.DefaultTypeAdapter, new)
+}
+
+// listsOf returns the elements of arg, which must be a list of lists.
+func listsOf(arg ref.Val) ([]traits.Lister, ref.Val) {
+	list, ok := arg.(traits.Lister)
+	if !ok {
+		return nil, types.NoSuchOverloadErr()
+	}
+	var lists []traits.Lister
+	it := list.Iterator()
+	for it.HasNext() == types.True {
+		l, ok := it.Next().(traits.Lister)
+		if !ok {
+			return nil, types.NoSuchOverloadErr()
+		}
+		lists = append(lists, l)
+	}
+	return lists, nil
+}
+
+func chunk(arg, size ref.Val) ref.Val {
+	list, ok := arg.(traits.Lister)
+	if !ok {
+		return types.NoSuchOverloadErr()
+	}
+	n, ok := size.(types.Int)
+	if !ok {
+		return types.ValOrErr(size, "no such overload")
+	}
+	if n <= 0 {
+		return types.NewErr("invalid chunk size: %d", n)
+	}
+	len := list.Size().(types.Int)
+	count := len / n
+	if len%n != 0 {
+		count++
+	}
+	return newLazyList(int(count), func(i int) ref.Val {
+		start := types.Int(i) * n
+		end := start + n
+		if end > len {
+			end = len
+		}
+		return subList(list, start, end)
+	})
+}
+
+func window(arg, size, step ref.Val) ref.Val {
+	list, ok := arg.(traits.Lister)
+	if !ok {
+		return types.NoSuchOverloadErr()
+	}
+	n, ok := size.(types.Int)
+	if !ok {
+		return types.ValOrErr(size, "no such overload")
+	}
+	if n <= 0 {
+		return types.NewErr("invalid window size: %d", n)
+	}
+	s, ok := step.(types.Int)
+	if !ok {
+		return types.ValOrErr(step, "no such overload")
+	}
+	if s <= 0 {
+		return types.NewErr("invalid window step: %d", s)
+	}
+	len := list.Size().(types.Int)
+	var count types.Int
+	if len >= n {
+		count = (len-n)/s + 1
+	}
+	return newLazyList(int(count), func(i int) ref.Val {
+		start := types.Int(i) * s
+		return subList(list, start, start+n)
+	})
+}
+
+func slice(args ...ref.Val) ref.Val {
+	if len(args) != 3 {
+		return types.NoSuchOverloadErr()
+	}
+	start, ok := args[1].(types.Int)
+	if !ok {
+		return types.ValOrErr(args[1], "no such overload")
+	}
+	end, ok := args[2].(types.Int)
+	if !ok {
+		return types.ValOrErr(args[2], "no such overload")
+	}
+	return sliceSequence(args[0], func(n types.Int) (types.Int, types.Int) {
+		return sliceIndex(start, n), sliceIndex(end, n)
+	})
+}
+
+func take(arg, count ref.Val) ref.Val {
+	c, ok := count.(types.Int)
+	if !ok {
+		return types.ValOrErr(count, "no such overload")
+	}
+	return sliceSequence(arg, func(n types.Int) (types.Int, types.Int) {
+		if c < 0 {
+			return sliceIndex(c, n), n
+		}
+		return 0, sliceIndex(c, n)
+	})
+}
+
+func skip(arg, count ref.Val) ref.Val {
+	c, ok := count.(types.Int)
+	if !ok {
+		return types.ValOrErr(count, "no such overload")
+	}
+	return sliceSequence(arg, func(n types.Int) (types.Int, types.Int) {
+		if c < 0 {
+			return 0, sliceIndex(c, n)
+		}
+		return sliceIndex(c, n), n
+	})
+}
+
+// first returns the first element of arg, or def if arg is empty and
+// def is not nil.
+func first(arg, def ref.Val) ref.Val {
+	return sliceElement(arg, def, "first", func(n types.Int) types.Int { return 0 })
+}
+
+// last returns the last element of arg, or def if arg is empty and
+// def is not nil.
+func last(arg, def ref.Val) ref.Val {
+	return sliceElement(arg, def, "last", func(n types.Int) types.Int { return n - 1 })
+}
+
+// sliceElement returns the element of the list, string or bytes arg at the
+// index returned by idx for the length of arg. If arg is empty, def is
+// returned or an error if def is nil.
+func sliceElement(arg, def ref.Val, name string, idx func(n types.Int) types.Int) ref.Val {
+	var n types.Int
+	switch arg := arg.(type) {
+	case traits.Lister:
+		n = arg.Size().(types.Int)
+	case types.String:
+		n = types.Int(utf8.RuneCountInString(string(arg)))
+	case types.Bytes:
+		n = types.Int(len(arg))
+	default:
+		return types.NoSuchOverloadErr()
+	}
+	if n == 0 {
+		if def == nil {
+			return types.NewErr("%s of empty %s", name, arg.Type().TypeName())
+		}
+		return def
+	}
+	i := idx(n)
+	if l, ok := arg.(traits.Lister); ok {
+		return l.Get(i)
+	}
+	return sliceSequence(arg, func(types.Int) (types.Int, types.Int) {
+		return i, i + 1
+	})
+}
+
+// sliceSequence returns the part of the list, string or bytes arg within the
+// bounds returned by bounds for the length of arg.
+func sliceSequence(arg ref.Val, bounds func(n types.Int) (start, end types.Int)) ref.Val {
+	switch arg := arg.(type) {
+	case traits.Lister:
+		start, end := bounds(arg.Size().(types.Int))
+		if start >= end {
+			return types.NewRefValList(types.DefaultTypeAdapter, nil)
+		}
+		return subList(arg, start, end)
+	case types.String:
+		r := []rune(arg)
+		start, end := bounds(types.Int(len(r)))
+		if start >= end {
+			return types.String("")
+		}
+		return types.String(r[start:end])
+	case types.Bytes:
+		start, end := bounds(types.Int(len(arg)))
+		if start >= end {
+			return types.Bytes{}
+		}
+		return arg[start:end]
+	default:
+		return types.NoSuchOverloadErr()
+	}
+}
+
+// sliceIndex returns idx as an index into a sequence of length n with
+// negative indexes counting back from the end and the result clamped
+// to [0, n].
+func sliceIndex(idx, n types.Int) types.Int {
+	if idx < 0 {
+		idx += n
+	}
+	if idx < 0 {
+		return 0
+	}
+	if idx > n {
+		return n
+	}
+	return idx
+}
+
+// subList returns a lazily evaluated view of list[start:end].
+func subList(list traits.Lister, start, end types.Int) ref.Val {
+	return newLazyList(int(end-start), func(i int) ref.Val {
+		return list.Get(start + types.Int(i))
+	})
+}
+
+// newLazyList returns a list of n elements where the i'th element is
+// obtained by calling get(i) when it is accessed.
+func newLazyList(n int, get func(i int) ref.Val) traits.Lister {
+	return lazyList{n: n, get: get}
+}
+
+// lazyList is a lazily evaluated list. Operations that require all the
+// elements of the list are performed on a materialized copy of the list.
+type lazyList struct {
+	n   int
+	get func(i int) ref.Val
+}
+
+func (l lazyList) Get(index ref.Val) ref.Val {
+	i, ok := index.(types.Int)
+	if !ok {
+		return types.ValOrErr(index, "unsupported index type '%s' in list", index.Type())
+	}
+	if i < 0 || i >= types.Int(l.n) {
+		return types.NewErr("index '%d' out of range in list size '%d'", i, l.n)
+	}
+	return l.get(int(i))
+}
+
+func (l lazyList) Size() ref.Val                 { return types.Int(l.n) }
+func (l lazyList) Iterator() traits.Iterator     { return &lazyListIterator{list: l} }
+func (l lazyList) Add(other ref.Val) ref.Val     { return l.materialize().Add(other) }
+func (l lazyList) Contains(elem ref.Val) ref.Val { return l.materialize().Contains(elem) }
+func (l lazyList) Equal(other ref.Val) ref.Val   { return l.materialize().Equal(other) }
+func (l lazyList) Type() ref.Type                { return types.ListType }
+func (l lazyList) Value() interface{}            { return l.materialize().Value() }
+
+func (l lazyList) ConvertToNative(typ reflect.Type) (interface{}, error) {
+	return l.materialize().ConvertToNative(typ)
+}
+
+func (l lazyList) ConvertToType(typ ref.Type) ref.Val {
+	switch typ {
+	case types.ListType:
+		return l
+	case types.TypeType:
+		return types.ListType
+	}
+	return types.NewErr("type conversion error from '%s' to '%s'", types.ListType, typ)
+}
+
+// materialize returns an eagerly evaluated copy of the list.
+func (l lazyList) materialize() traits.Lister {
+	elems := make([]ref.Val, l.n)
+	for i := range elems {
+		elems[i] = l.get(i)
+	}
+	return types.NewRefValList(types.DefaultTypeAdapter, elems)
+}
+
+// lazyListIterator is an iterator over a lazyList.
+type lazyListIterator struct {
+	list lazyList
+	idx  int
+}
+
+func (it *lazyListIterator) HasNext() ref.Val { return types.Bool(it.idx < it.list.n) }
+
+func (it *lazyListIterator) Next() ref.Val {
+	if it.idx >= it.list.n {
+		return nil
+	}
+	v := it.list.get(it.idx)
+	it.idx++
+	return v
+}
+
+func (*lazyListIterator) ConvertToNative(typ reflect.Type) (interface{}, error) {
+	return nil, fmt.Errorf("type conversion on iterators not supported")
+}
+func (*lazyListIterator) ConvertToType(ref.Type) ref.Val {
+	return types.NewErr("no such overload")
+}
+func (*lazyListIterator) Equal(ref.Val) ref.Val { return types.NewErr("no such overload") }
+func (*lazyListIterator) Type() ref.Type        { return types.IteratorType }
+func (*lazyListIterator) Value() interface{}    { return nil }
@@ -34,6 +34,21 @@ import (
 //     "hello world".base64()  // return "aGVsbG8gd29ybGQ="
 //
 //
+// Base64 Decode
+//
+// Returns a bytes of the decoding of a base64 encoded string or bytes:
+//
+//     base64_decode(<bytes>) -> <bytes>
+//     base64_decode(<string>) -> <bytes>
+//     <bytes>.base64_decode() -> <bytes>
+//     <string>.base64_decode() -> <bytes>
+//
+// Examples:
+//
+//     "aGVsbG8gd29ybGQ=".base64_decode()  // return b"hello world"
+//     "aGVsbG8gd29ybGQ".base64_decode()   // return error
+//
+//
 // Base64 Raw
 //
 // Returns a string of the raw unpadded base64 encoding of a string or bytes:
@@ -48,6 +63,81 @@ import (
 //     "hello world".base64_raw()  // return "aGVsbG8gd29ybGQ"
 //
 //
+// Base64 Raw Decode
+//
+// Returns a bytes of the decoding of a raw unpadded base64 encoded string or
+// bytes:
+//
+//     base64_raw_decode(<bytes>) -> <bytes>
+//     base64_raw_decode(<string>) -> <bytes>
+//     <bytes>.base64_raw_decode() -> <bytes>
+//     <string>.base64_raw_decode() -> <bytes>
+//
+// Examples:
+//
+//     "aGVsbG8gd29ybGQ".base64_raw_decode()  // return b"hello world"
+//
+//
+// Base64 URL
+//
+// Returns a string of the URL and filename safe base64 encoding of a string
+// or bytes:
+//
+//     base64_url(<bytes>) -> <string>
+//     base64_url(<string>) -> <string>
+//     <bytes>.base64_url() -> <string>
+//     <string>.base64_url() -> <string>
+//
+// Examples:
+//
+//     b"\xfb\xff".base64_url()  // return "-_8="
+//
+//
+// Base64 URL Decode
+//
+// Returns a bytes of the decoding of a URL and filename safe base64 encoded
+// string or bytes:
+//
+//     base64_url_decode(<bytes>) -> <bytes>
+//     base64_url_decode(<string>) -> <bytes>
+//     <bytes>.base64_url_decode() -> <bytes>
+//     <string>.base64_url_decode() -> <bytes>
+//
+// Examples:
+//
+//     "-_8=".base64_url_decode()  // return b"\xfb\xff"
+//
+//
+// Base64 Raw URL
+//
+// Returns a string of the raw unpadded URL and filename safe base64 encoding
+// of a string or bytes:
+//
+//     base64_raw_url(<bytes>) -> <string>
+//     base64_raw_url(<string>) -> <string>
+//     <bytes>.base64_raw_url() -> <string>
+//     <string>.base64_raw_url() -> <string>
+//
+// Examples:
+//
+//     b"\xfb\xff".base64_raw_url()  // return "-_8"
+//
+//
+// Base64 Raw URL Decode
+//
+// Returns a bytes of the decoding of a raw unpadded URL and filename safe
+// base64 encoded string or bytes:
+//
+//     base64_raw_url_decode(<bytes>) -> <bytes>
+//     base64_raw_url_decode(<string>) -> <bytes>
+//     <bytes>.base64_raw_url_decode() -> <bytes>
+//     <string>.base64_raw_url_decode() -> <bytes>
+//
+// Examples:
+//
+//     "-_8".base64_raw_url_decode()  // return b"\xfb\xff"
+//
+//
 // Hex
 //
 // Returns a string of the hexadecimal representation of a string or bytes:
@@ -62,6 +152,21 @@ import (
 //     "hello world".hex()  // return "68656c6c6f20776f726c64"
 //
 //
+// Hex Decode
+//
+// Returns a bytes of the decoding of a hexadecimal encoded string or bytes:
+//
+//     hex_decode(<bytes>) -> <bytes>
+//     hex_decode(<string>) -> <bytes>
+//     <bytes>.hex_decode() -> <bytes>
+//     <string>.hex_decode() -> <bytes>
+//
+// Examples:
+//
+//     "68656c6c6f20776f726c64".hex_decode()  // return b"hello world"
+//     "68656c6c6f20776f726c6".hex_decode()   // return error
+//
+//
 // SHA-1
 //
 // Returns a bytes of the sha-1 hash of a string or bytes:
@@ -149,6 +254,28 @@ func (cryptoLib) CompileOptions() []cel.EnvOption {
 					decls.String,
 				),
 			),
+			decls.NewFunction("base64_decode",
+				decls.NewOverload(
+					"base64_decode_bytes",
+					[]*expr.Type{decls.Bytes},
+					decls.Bytes,
+				),
+				decls.NewInstanceOverload(
+					"bytes_base64_decode",
+					[]*expr.Type{decls.Bytes},
+					decls.Bytes,
+				),
+				decls.NewOverload(
+					"base64_decode_string",
+					[]*expr.Type{decls.String},
+					decls.Bytes,
+				),
+				decls.NewInstanceOverload(
+					"string_base64_decode",
+					[]*expr.Type{decls.String},
+					decls.Bytes,
+				),
+			),
 			decls.NewFunction("base64_raw",
 				decls.NewOverload(
 					"base64_raw_bytes",
@@ -171,6 +298,116 @@ func (cryptoLib) CompileOptions() []cel.EnvOption {
 					decls.String,
 				),
 			),
+			decls.NewFunction("base64_raw_decode",
+				decls.NewOverload(
+					"base64_raw_decode_bytes",
+					[]*expr.Type{decls.Bytes},
+					decls.Bytes,
+				),
+				decls.NewInstanceOverload(
+					"bytes_base64_raw_decode",
+					[]*expr.Type{decls.Bytes},
+					decls.Bytes,
+				),
+				decls.NewOverload(
+					"base64_raw_decode_string",
+					[]*expr.Type{decls.String},
+					decls.Bytes,
+				),
+				decls.NewInstanceOverload(
+					"string_base64_raw_decode",
+					[]*expr.Type{decls.String},
+					decls.Bytes,
+				),
+			),
+			decls.NewFunction("base64_url",
+				decls.NewOverload(
+					"base64_url_bytes",
+					[]*expr.Type{decls.Bytes},
+					decls.String,
+				),
+				decls.NewInstanceOverload(
+					"bytes_base64_url",
+					[]*expr.Type{decls.Bytes},
+					decls.String,
+				),
+				decls.NewOverload(
+					"base64_url_string",
+					[]*expr.Type{decls.String},
+					decls.String,
+				),
+				decls.NewInstanceOverload(
+					"string_base64_url",
+					[]*expr.Type{decls.String},
+					decls.String,
+				),
+			),
+			decls.NewFunction("base64_url_decode",
+				decls.NewOverload(
+					"base64_url_decode_bytes",
+					[]*expr.Type{decls.Bytes},
+					decls.Bytes,
+				),
+				decls.NewInstanceOverload(
+					"bytes_base64_url_decode",
+					[]*expr.Type{decls.Bytes},
+					decls.Bytes,
+				),
+				decls.NewOverload(
+					"base64_url_decode_string",
+					[]*expr.Type{decls.String},
+					decls.Bytes,
+				),
+				decls.NewInstanceOverload(
+					"string_base64_url_decode",
+					[]*expr.Type{decls.String},
+					decls.Bytes,
+				),
+			),
+			decls.NewFunction("base64_raw_url",
+				decls.NewOverload(
+					"base64_raw_url_bytes",
+					[]*expr.Type{decls.Bytes},
+					decls.String,
+				),
+				decls.NewInstanceOverload(
+					"bytes_base64_raw_url",
+					[]*expr.Type{decls.Bytes},
+					decls.String,
+				),
+				decls.NewOverload(
+					"base64_raw_url_string",
+					[]*expr.Type{decls.String},
+					decls.String,
+				),
+				decls.NewInstanceOverload(
+					"string_base64_raw_url",
+					[]*expr.Type{decls.String},
+					decls.String,
+				),
+			),
+			decls.NewFunction("base64_raw_url_decode",
+				decls.NewOverload(
+					"base64_raw_url_decode_bytes",
+					[]*expr.Type{decls.Bytes},
+					decls.Bytes,
+				),
+				decls.NewInstanceOverload(
+					"bytes_base64_raw_url_decode",
+					[]*expr.Type{decls.Bytes},
+					decls.Bytes,
+				),
+				decls.NewOverload(
+					"base64_raw_url_decode_string",
+					[]*expr.Type{decls.String},
+					decls.Bytes,
+				),
+				decls.NewInstanceOverload(
+					"string_base64_raw_url_decode",
+					[]*expr.Type{decls.String},
+					decls.Bytes,
+				),
+			),
 			decls.NewFunction("hex",
 				decls.NewOverload(
 					"hex_bytes",
@@ -193,6 +430,28 @@ func (cryptoLib) CompileOptions() []cel.EnvOption {
 					decls.String,
 				),
 			),
+			decls.NewFunction("hex_decode",
+				decls.NewOverload(
+					"hex_decode_bytes",
+					[]*expr.Type{decls.Bytes},
+					decls.Bytes,
+				),
+				decls.NewInstanceOverload(
+					"bytes_hex_decode",
+					[]*expr.Type{decls.Bytes},
+					decls.Bytes,
+				),
+				decls.NewOverload(
+					"hex_decode_string",
+					[]*expr.Type{decls.String},
+					decls.Bytes,
+				),
+				decls.NewInstanceOverload(
+					"string_hex_decode",
+					[]*expr.Type{decls.String},
+					decls.Bytes,
+				),
+			),
 			decls.NewFunction("sha1",
 				decls.NewOverload(
 					"sha1_bytes",
@@ -290,6 +549,24 @@ func (cryptoLib) ProgramOptions() []cel.ProgramOption {
 				Unary:    base64Encode,
 			},
 		),
+		cel.Functions(
+			&functions.Overload{
+				Operator: "base64_decode_bytes",
+				Unary:    base64Decode,
+			},
+			&functions.Overload{
+				Operator: "bytes_base64_decode",
+				Unary:    base64Decode,
+			},
+			&functions.Overload{
+				Operator: "base64_decode_string",
+				Unary:    base64Decode,
+			},
+			&functions.Overload{
+				Operator: "string_base64_decode",
+				Unary:    base64Decode,
+			},
+		),
 		cel.Functions(
 			&functions.Overload{
 				Operator: "base64_raw_bytes",
@@ -308,6 +585,96 @@ func (cryptoLib) ProgramOptions() []cel.ProgramOption {
 				Unary:    base64RawEncode,
 			},
 		),
+		cel.Functions(
+			&functions.Overload{
+				Operator: "base64_raw_decode_bytes",
+				Unary:    base64RawDecode,
+			},
+			&functions.Overload{
+				Operator: "bytes_base64_raw_decode",
+				Unary:    base64RawDecode,
+			},
+			&functions.Overload{
+				Operator: "base64_raw_decode_string",
+				Unary:    base64RawDecode,
+			},
+			&functions.Overload{
+				Operator: "string_base64_raw_decode",
+				Unary:    base64RawDecode,
+			},
+		),
+		cel.Functions(
+			&functions.Overload{
+				Operator: "base64_url_bytes",
+				Unary:    base64URLEncode,
+			},
+			&functions.Overload{
+				Operator: "bytes_base64_url",
+				Unary:    base64URLEncode,
+			},
+			&functions.Overload{
+				Operator: "base64_url_string",
+				Unary:    base64URLEncode,
+			},
+			&functions.Overload{
+				Operator: "string_base64_url",
+				Unary:    base64URLEncode,
+			},
+		),
+		cel.Functions(
+			&functions.Overload{
+				Operator: "base64_url_decode_bytes",
+				Unary:    base64URLDecode,
+			},
+			&functions.Overload{
+				Operator: "bytes_base64_url_decode",
+				Unary:    base64URLDecode,
+			},
+			&functions.Overload{
+				Operator: "base64_url_decode_string",
+				Unary:    base64URLDecode,
+			},
+			&functions.Overload{
+				Operator: "string_base64_url_decode",
+				Unary:    base64URLDecode,
+			},
+		),
+		cel.Functions(
+			&functions.Overload{
+				Operator: "base64_raw_url_bytes",
+				Unary:    base64RawURLEncode,
+			},
+			&functions.Overload{
+				Operator: "bytes_base64_raw_url",
+				Unary:    base64RawURLEncode,
+			},
+			&functions.Overload{
+				Operator: "base64_raw_url_string",
+				Unary:    base64RawURLEncode,
+			},
+			&functions.Overload{
+				Operator: "string_base64_raw_url",
+				Unary:    base64RawURLEncode,
+			},
+		),
+		cel.Functions(
+			&functions.Overload{
+				Operator: "base64_raw_url_decode_bytes",
+				Unary:    base64RawURLDecode,
+			},
+			&functions.Overload{
+				Operator: "bytes_base64_raw_url_decode",
+				Unary:    base64RawURLDecode,
+			},
+			&functions.Overload{
+				Operator: "base64_raw_url_decode_string",
+				Unary:    base64RawURLDecode,
+			},
+			&functions.Overload{
+				Operator: "string_base64_raw_url_decode",
+				Unary:    base64RawURLDecode,
+			},
+		),
 		cel.Functions(
 			&functions.Overload{
 				Operator: "hex_bytes",
@@ -326,6 +693,24 @@ func (cryptoLib) ProgramOptions() []cel.ProgramOption {
 				Unary:    hexEncode,
 			},
 		),
+		cel.Functions(
+			&functions.Overload{
+				Operator: "hex_decode_bytes",
+				Unary:    hexDecode,
+			},
+			&functions.Overload{
+				Operator: "bytes_hex_decode",
+				Unary:    hexDecode,
+			},
+			&functions.Overload{
+				Operator: "hex_decode_string",
+				Unary:    hexDecode,
+			},
+			&functions.Overload{
+				Operator: "string_hex_decode",
+				Unary:    hexDecode,
+			},
+		),
 		cel.Functions(
 			&functions.Overload{
 				Operator: "sha1_bytes",
@@ -411,6 +796,64 @@ func base64RawEncode(val ref.Val) ref.Val {
 	}
 }
 
+func base64Decode(val ref.Val) ref.Val {
+	return decodeBase64(val, base64.StdEncoding, "base64_decode")
+}
+
+func base64RawDecode(val ref.Val) ref.Val {
+	return decodeBase64(val, base64.RawStdEncoding, "base64_raw_decode")
+}
+
+func base64URLEncode(val ref.Val) ref.Val {
+	switch val := val.(type) {
+	case types.Bytes:
+		return types.String(base64.URLEncoding.EncodeToString(val))
+	case types.String:
+		return types.String(base64.URLEncoding.EncodeToString([]byte(val)))
+	default:
+		return types.NewErr("invalid type for base64_url: %s", val.Type())
+	}
+}
+
+func base64URLDecode(val ref.Val) ref.Val {
+	return decodeBase64(val, base64.URLEncoding, "base64_url_decode")
+}
+
+func base64RawURLEncode(val ref.Val) ref.Val {
+	switch val := val.(type) {
+	case types.Bytes:
+		return types.String(base64.RawURLEncoding.EncodeToString(val))
+	case types.String:
+		return types.String(base64.RawURLEncoding.EncodeToString([]byte(val)))
+	default:
+		return types.NewErr("invalid type for base64_raw_url: %s", val.Type())
+	}
+}
+
+func base64RawURLDecode(val ref.Val) ref.Val {
+	return decodeBase64(val, base64.RawURLEncoding, "base64_raw_url_decode")
+}
+
+// decodeBase64 returns the bytes decoded from val using enc. The name of
+// the calling function is used in error messages.
+func decodeBase64(val ref.Val, enc *base64.Encoding, name string) ref.Val {
+	var src []byte
+	switch val := val.(type) {
+	case types.Bytes:
+		src = val
+	case types.String:
+		src = []byte(val)
+	default:
+		return types.NewErr("invalid type for %s: %s", name, val.Type())
+	}
+	dst := make([]byte, enc.DecodedLen(len(src)))
+	n, err := enc.Decode(dst, src)
+	if err != nil {
+		return types.NewErr("failed to decode base64: %v", err)
+	}
+	return types.Bytes(dst[:n])
+}
+
 func hexEncode(val ref.Val) ref.Val {
 	switch val := val.(type) {
 	case types.Bytes:
@@ -422,6 +865,24 @@ func hexEncode(val ref.Val) ref.Val {
 	}
 }
 
+func hexDecode(val ref.Val) ref.Val {
+	var src []byte
+	switch val := val.(type) {
+	case types.Bytes:
+		src = val
+	case types.String:
+		src = []byte(val)
+	default:
+		return types.NewErr("invalid type for hex_decode: %s", val.Type())
+	}
+	dst := make([]byte, hex.DecodedLen(len(src)))
+	n, err := hex.Decode(dst, src)
+	if err != nil {
+		return types.NewErr("failed to decode hex: %v", err)
+	}
+	return types.Bytes(dst[:n])
+}
+
 func sha1Hash(val ref.Val) ref.Val {
 	switch val := val.(type) {
 	case types.Bytes:
@@ -0,0 +1,237 @@
+package lib
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Nominal lengths of calendar units used for durations.
+const (
+	nominalDay   = 24 * time.Hour
+	nominalWeek  = 7 * nominalDay
+	nominalMonth = 30 * nominalDay
+	nominalYear  = 365 * nominalDay
+)
+
+// durationComponents returns the magnitude of d split into whole days,
+// hours, minutes and seconds and the remaining nanoseconds, and whether
+// d is negative.
+func durationComponents(d time.Duration) (days, hours, minutes, seconds, nanos uint64, neg bool) {
+	u := uint64(d)
+	if d < 0 {
+		neg = true
+		u = -u
+	}
+	days, u = u/uint64(nominalDay), u%uint64(nominalDay)
+	hours, u = u/uint64(time.Hour), u%uint64(time.Hour)
+	minutes, u = u/uint64(time.Minute), u%uint64(time.Minute)
+	seconds, nanos = u/uint64(time.Second), u%uint64(time.Second)
+	return days, hours, minutes, seconds, nanos, neg
+}
+
+// formatSeconds returns the decimal representation of seconds and nanos
+// with trailing fractional zeros removed.
+func formatSeconds(seconds, nanos uint64) string {
+	s := strconv.FormatUint(seconds, 10)
+	if nanos == 0 {
+		return s
+	}
+	return s + "." + strings.TrimRight(fmt.Sprintf("%09d", nanos), "0")
+}
+
+// formatHumanDuration returns d as a space separated list of days, hours,
+// minutes and seconds, omitting zero components.
+func formatHumanDuration(d time.Duration) string {
+	if d == 0 {
+		return "0 seconds"
+	}
+	days, hours, minutes, seconds, nanos, neg := durationComponents(d)
+	var parts []string
+	for _, c := range []struct {
+		n    uint64
+		unit string
+	}{
+		{n: days, unit: "day"},
+		{n: hours, unit: "hour"},
+		{n: minutes, unit: "minute"},
+	} {
+		if c.n == 0 {
+			continue
+		}
+		if c.n == 1 {
+			parts = append(parts, "1 "+c.unit)
+		} else {
+			parts = append(parts, strconv.FormatUint(c.n, 10)+" "+c.unit+"s")
+		}
+	}
+	switch {
+	case seconds == 1 && nanos == 0:
+		parts = append(parts, "1 second")
+	case seconds != 0 || nanos != 0:
+		parts = append(parts, formatSeconds(seconds, nanos)+" seconds")
+	}
+	s := strings.Join(parts, " ")
+	if neg {
+		s = "-" + s
+	}
+	return s
+}
+
+// formatISODuration returns d as an ISO 8601 duration using days, hours,
+// minutes and seconds. Negative durations are prefixed with a minus sign.
+func formatISODuration(d time.Duration) string {
+	if d == 0 {
+		return "PT0S"
+	}
+	days, hours, minutes, seconds, nanos, neg := durationComponents(d)
+	var b strings.Builder
+	if neg {
+		b.WriteByte('-')
+	}
+	b.WriteByte('P')
+	if days != 0 {
+		b.WriteString(strconv.FormatUint(days, 10))
+		b.WriteByte('D')
+	}
+	if hours == 0 && minutes == 0 && seconds == 0 && nanos == 0 {
+		return b.String()
+	}
+	b.WriteByte('T')
+	if hours != 0 {
+		b.WriteString(strconv.FormatUint(hours, 10))
+		b.WriteByte('H')
+	}
+	if minutes != 0 {
+		b.WriteString(strconv.FormatUint(minutes, 10))
+		b.WriteByte('M')
+	}
+	if seconds != 0 || nanos != 0 {
+		b.WriteString(formatSeconds(seconds, nanos))
+		b.WriteByte('S')
+	}
+	return b.String()
+}
+
+// parseISODuration returns the duration represented by the ISO 8601
+// duration s. Years, months and weeks are taken to be 365, 30 and 7 days
+// long. Only the last component may have a fractional part, which may
+// be separated by a '.' or a ','. A leading sign is accepted.
+func parseISODuration(s string) (time.Duration, error) {
+	orig := s
+	neg := false
+	switch {
+	case strings.HasPrefix(s, "-"):
+		neg = true
+		s = s[1:]
+	case strings.HasPrefix(s, "+"):
+		s = s[1:]
+	}
+	if !strings.HasPrefix(s, "P") {
+		return 0, fmt.Errorf("invalid ISO 8601 duration: %q", orig)
+	}
+	s = s[1:]
+
+	dateUnits := []struct {
+		designator byte
+		unit       time.Duration
+	}{
+		{'Y', nominalYear}, {'M', nominalMonth}, {'W', nominalWeek}, {'D', nominalDay},
+	}
+	timeUnits := []struct {
+		designator byte
+		unit       time.Duration
+	}{
+		{'H', time.Hour}, {'M', time.Minute}, {'S', time.Second},
+	}
+
+	var (
+		total      float64
+		d          time.Duration
+		components int
+		inTime     bool
+		fractional bool
+	)
+	units := dateUnits
+	for len(s) != 0 {
+		if s[0] == 'T' {
+			if inTime {
+				return 0, fmt.Errorf("invalid ISO 8601 duration: %q", orig)
+			}
+			inTime = true
+			units = timeUnits
+			s = s[1:]
+			if len(s) == 0 {
+				return 0, fmt.Errorf("invalid ISO 8601 duration: %q", orig)
+			}
+			continue
+		}
+		if fractional {
+			return 0, fmt.Errorf("fractional component must be last in ISO 8601 duration: %q", orig)
+		}
+
+		i := 0
+		for i < len(s) && '0' <= s[i] && s[i] <= '9' {
+			i++
+		}
+		integer := s[:i]
+		var frac string
+		if i < len(s) && (s[i] == '.' || s[i] == ',') {
+			fractional = true
+			j := i + 1
+			for j < len(s) && '0' <= s[j] && s[j] <= '9' {
+				j++
+			}
+			frac = s[i+1 : j]
+			i = j
+			if frac == "" {
+				return 0, fmt.Errorf("invalid ISO 8601 duration: %q", orig)
+			}
+		}
+		if integer == "" || i == len(s) {
+			return 0, fmt.Errorf("invalid ISO 8601 duration: %q", orig)
+		}
+		designator := s[i]
+		s = s[i+1:]
+
+		// Designators must appear in order and at most once.
+		k := 0
+		for k < len(units) && units[k].designator != designator {
+			k++
+		}
+		if k == len(units) {
+			return 0, fmt.Errorf("invalid ISO 8601 duration: %q", orig)
+		}
+		unit := units[k].unit
+		units = units[k+1:]
+
+		n, err := strconv.ParseUint(integer, 10, 64)
+		if err != nil || n > uint64(math.MaxInt64/unit) {
+			return 0, fmt.Errorf("ISO 8601 duration out of range: %q", orig)
+		}
+		d += time.Duration(n) * unit
+		total += float64(n) * float64(unit)
+		if frac != "" {
+			f, err := strconv.ParseFloat("0."+frac, 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid ISO 8601 duration: %q", orig)
+			}
+			part := math.Round(f * float64(unit))
+			d += time.Duration(part)
+			total += part
+		}
+		if d < 0 || total > math.MaxInt64 {
+			return 0, fmt.Errorf("ISO 8601 duration out of range: %q", orig)
+		}
+		components++
+	}
+	if components == 0 {
+		return 0, fmt.Errorf("invalid ISO 8601 duration: %q", orig)
+	}
+	if neg {
+		d = -d
+	}
+	return d, nil
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/google/cel-go/checker/decls"
 	"github.com/google/cel-go/common/types"
 	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/common/types/traits"
 	"github.com/google/cel-go/interpreter/functions"
 	expr "google.golang.org/genproto/googleapis/api/expr/v1alpha1"
 )
@@ -64,6 +65,73 @@ import (
 //     '{"a":1}{"b":2}'.decode_json_stream()   // return [{"a":1}, {"b":2}]
 //     b'{"a":1}{"b":2}'.decode_json_stream()  // return [{"a":1}, {"b":2}]
 //
+//
+// Apply JSON Patch
+//
+// apply_json_patch returns the receiver or first parameter with the RFC 6902
+// JSON patch operations in the list parameter applied in order. Locations are
+// RFC 6901 JSON pointers. If any operation fails, including a failed test
+// operation, an error is returned:
+//
+//     <dyn>.apply_json_patch(<list<dyn>>) -> <dyn>
+//     apply_json_patch(<dyn>, <list<dyn>>) -> <dyn>
+//
+// Examples:
+//
+//     {"a":1, "b":[1, 2]}.apply_json_patch([
+//         {"op":"replace", "path":"/a", "value":2},
+//         {"op":"add", "path":"/b/-", "value":3},
+//     ])                                               // return {"a":2, "b":[1, 2, 3]}
+//     {"a":1}.apply_json_patch([{"op":"test", "path":"/a", "value":2}])
+//                                                      // return error
+//
+//
+// Apply Merge Patch
+//
+// apply_merge_patch returns the receiver or first parameter with the RFC 7396
+// JSON merge patch parameter applied. Null values in the patch delete fields:
+//
+//     <dyn>.apply_merge_patch(<dyn>) -> <dyn>
+//     apply_merge_patch(<dyn>, <dyn>) -> <dyn>
+//
+// Examples:
+//
+//     {"a":1, "b":{"c":2, "d":3}}.apply_merge_patch({"a":null, "b":{"c":4}})
+//                                                      // return {"b":{"c":4, "d":3}}
+//
+//
+// Diff JSON Patch
+//
+// diff_json_patch returns a list of RFC 6902 JSON patch operations that
+// transform the receiver or first parameter into the second value. Map fields
+// are compared in sorted key order and list elements are compared by index:
+//
+//     <dyn>.diff_json_patch(<dyn>) -> <list<map<string,dyn>>>
+//     diff_json_patch(<dyn>, <dyn>) -> <list<map<string,dyn>>>
+//
+// Examples:
+//
+//     {"a":1, "b":[1, 2], "c":"x"}.diff_json_patch({"a":2, "b":[1], "d":"y"})
+//         // return [{"op":"replace", "path":"/a", "value":2},
+//         //         {"op":"remove", "path":"/b/1"},
+//         //         {"op":"remove", "path":"/c"},
+//         //         {"op":"add", "path":"/d", "value":"y"}]
+//
+//
+// Diff Merge Patch
+//
+// diff_merge_patch returns an RFC 7396 JSON merge patch that transforms the
+// receiver or first parameter into the second value. Merge patches cannot
+// represent fields set to null, so these are represented as deletions:
+//
+//     <dyn>.diff_merge_patch(<dyn>) -> <dyn>
+//     diff_merge_patch(<dyn>, <dyn>) -> <dyn>
+//
+// Examples:
+//
+//     {"a":1, "b":{"c":2, "d":3}}.diff_merge_patch({"b":{"c":4, "d":3}})
+//                                                      // return {"a":null, "b":{"c":4}}
+//
 func JSON(adapter ref.TypeAdapter) cel.EnvOption {
 	if adapter == nil {
 		adapter = types.DefaultTypeAdapter
@@ -134,6 +202,54 @@ func (jsonLib) CompileOptions() []cel.EnvOption {
 					decls.NewListType(decls.Dyn),
 				),
 			),
+			decls.NewFunction("apply_json_patch",
+				decls.NewOverload(
+					"apply_json_patch_dyn_list",
+					[]*expr.Type{decls.Dyn, decls.NewListType(decls.Dyn)},
+					decls.Dyn,
+				),
+				decls.NewInstanceOverload(
+					"dyn_apply_json_patch_list",
+					[]*expr.Type{decls.Dyn, decls.NewListType(decls.Dyn)},
+					decls.Dyn,
+				),
+			),
+			decls.NewFunction("apply_merge_patch",
+				decls.NewOverload(
+					"apply_merge_patch_dyn_dyn",
+					[]*expr.Type{decls.Dyn, decls.Dyn},
+					decls.Dyn,
+				),
+				decls.NewInstanceOverload(
+					"dyn_apply_merge_patch_dyn",
+					[]*expr.Type{decls.Dyn, decls.Dyn},
+					decls.Dyn,
+				),
+			),
+			decls.NewFunction("diff_json_patch",
+				decls.NewOverload(
+					"diff_json_patch_dyn_dyn",
+					[]*expr.Type{decls.Dyn, decls.Dyn},
+					decls.NewListType(decls.NewMapType(decls.String, decls.Dyn)),
+				),
+				decls.NewInstanceOverload(
+					"dyn_diff_json_patch_dyn",
+					[]*expr.Type{decls.Dyn, decls.Dyn},
+					decls.NewListType(decls.NewMapType(decls.String, decls.Dyn)),
+				),
+			),
+			decls.NewFunction("diff_merge_patch",
+				decls.NewOverload(
+					"diff_merge_patch_dyn_dyn",
+					[]*expr.Type{decls.Dyn, decls.Dyn},
+					decls.Dyn,
+				),
+				decls.NewInstanceOverload(
+					"dyn_diff_merge_patch_dyn",
+					[]*expr.Type{decls.Dyn, decls.Dyn},
+					decls.Dyn,
+				),
+			),
 		),
 	}
 }
@@ -170,6 +286,46 @@ func (l jsonLib) ProgramOptions() []cel.ProgramOption {
 				Unary:    l.decodeJSONStream,
 			},
 		),
+		cel.Functions(
+			&functions.Overload{
+				Operator: "apply_json_patch_dyn_list",
+				Binary:   jsonPatch,
+			},
+			&functions.Overload{
+				Operator: "dyn_apply_json_patch_list",
+				Binary:   jsonPatch,
+			},
+		),
+		cel.Functions(
+			&functions.Overload{
+				Operator: "apply_merge_patch_dyn_dyn",
+				Binary:   mergePatch,
+			},
+			&functions.Overload{
+				Operator: "dyn_apply_merge_patch_dyn",
+				Binary:   mergePatch,
+			},
+		),
+		cel.Functions(
+			&functions.Overload{
+				Operator: "diff_json_patch_dyn_dyn",
+				Binary:   jsonPatchDiff,
+			},
+			&functions.Overload{
+				Operator: "dyn_diff_json_patch_dyn",
+				Binary:   jsonPatchDiff,
+			},
+		),
+		cel.Functions(
+			&functions.Overload{
+				Operator: "diff_merge_patch_dyn_dyn",
+				Binary:   diffMergePatch,
+			},
+			&functions.Overload{
+				Operator: "dyn_diff_merge_patch_dyn",
+				Binary:   diffMergePatch,
+			},
+		),
 	}
 }
 
@@ -246,3 +402,23 @@ func (l jsonLib) decodeJSONStream(val ref.Val) ref.Val {
 	}
 	return l.adapter.NativeToValue(s)
 }
+
+func jsonPatch(val, patch ref.Val) ref.Val {
+	ops, ok := patch.(traits.Lister)
+	if !ok {
+		return types.ValOrErr(patch, "no such overload")
+	}
+	res, err := applyJSONPatch(val, ops)
+	if err != nil {
+		return types.NewErr("failed to apply JSON patch: %v", err)
+	}
+	return res
+}
+
+func mergePatch(val, patch ref.Val) ref.Val {
+	return applyMergePatch(val, patch)
+}
+
+func jsonPatchDiff(a, b ref.Val) ref.Val {
+	return types.NewRefValList(types.DefaultTypeAdapter, diffJSONPatch(a, b))
+}
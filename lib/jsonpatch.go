@@ -0,0 +1,440 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/common/types/traits"
+)
+
+// parsePointer returns the reference tokens of the RFC 6901 JSON pointer p.
+func parsePointer(p string) ([]string, error) {
+	if p == "" {
+		return nil, nil
+	}
+	if p[0] != '/' {
+		return nil, fmt.Errorf("pointer does not begin with /: %q", p)
+	}
+	tokens := strings.Split(p[1:], "/")
+	for i, t := range tokens {
+		if strings.Contains(strings.ReplaceAll(strings.ReplaceAll(t, "~0", ""), "~1", ""), "~") {
+			return nil, fmt.Errorf("invalid escape in pointer: %q", p)
+		}
+		tokens[i] = strings.ReplaceAll(strings.ReplaceAll(t, "~1", "/"), "~0", "~")
+	}
+	return tokens, nil
+}
+
+// pointerToken returns the escaped JSON pointer reference token for k.
+func pointerToken(k ref.Val) string {
+	var s string
+	if k, ok := k.(types.String); ok {
+		s = string(k)
+	} else {
+		s = fmt.Sprint(k.Value())
+	}
+	return strings.ReplaceAll(strings.ReplaceAll(s, "~", "~0"), "/", "~1")
+}
+
+// pointerIndex returns the list index referred to by the token t. If end is
+// true, the "-" token is accepted and refers to the index after the last
+// element.
+func pointerIndex(list traits.Lister, t string, end bool) (int, error) {
+	n := int(list.Size().(types.Int))
+	if t == "-" && end {
+		return n, nil
+	}
+	if t == "" || (len(t) > 1 && t[0] == '0') || strings.TrimLeft(t, "0123456789") != "" {
+		return 0, fmt.Errorf("invalid array index: %q", t)
+	}
+	i, err := strconv.Atoi(t)
+	if err != nil {
+		return 0, fmt.Errorf("invalid array index: %q", t)
+	}
+	if i > n || (i == n && !end) {
+		return 0, fmt.Errorf("array index out of range: %d", i)
+	}
+	return i, nil
+}
+
+// pointerGet returns the value in v referred to by tokens.
+func pointerGet(v ref.Val, tokens []string) (ref.Val, error) {
+	for _, t := range tokens {
+		switch obj := v.(type) {
+		case traits.Mapper:
+			val, ok := obj.Find(types.String(t))
+			if !ok {
+				return nil, fmt.Errorf("no such key: %q", t)
+			}
+			v = val
+		case traits.Lister:
+			i, err := pointerIndex(obj, t, false)
+			if err != nil {
+				return nil, err
+			}
+			v = obj.Get(types.Int(i))
+		default:
+			return nil, fmt.Errorf("cannot index %s with %q", v.Type(), t)
+		}
+	}
+	return v, nil
+}
+
+// pointerUpdate returns v with the container referred to by all but the
+// last of tokens replaced by the result of calling fn with the container
+// and the last token. tokens must not be empty.
+func pointerUpdate(v ref.Val, tokens []string, fn func(parent ref.Val, last string) (ref.Val, error)) (ref.Val, error) {
+	if len(tokens) == 1 {
+		return fn(v, tokens[0])
+	}
+	child, err := pointerGet(v, tokens[:1])
+	if err != nil {
+		return nil, err
+	}
+	child, err = pointerUpdate(child, tokens[1:], fn)
+	if err != nil {
+		return nil, err
+	}
+	switch obj := v.(type) {
+	case traits.Mapper:
+		return mapWith(obj, types.String(tokens[0]), child), nil
+	case traits.Lister:
+		i, _ := pointerIndex(obj, tokens[0], false)
+		return listSplice(obj, i, 1, child), nil
+	default:
+		// Unreachable since pointerGet succeeded.
+		return nil, fmt.Errorf("cannot index %s with %q", v.Type(), tokens[0])
+	}
+}
+
+// listSplice returns a copy of list with n elements removed from index i
+// and the elements of ins inserted in their place.
+func listSplice(list traits.Lister, i, n int, ins ...ref.Val) ref.Val {
+	size := int(list.Size().(types.Int))
+	new := make([]ref.Val, 0, size-n+len(ins))
+	for j := 0; j < i; j++ {
+		new = append(new, list.Get(types.Int(j)))
+	}
+	new = append(new, ins...)
+	for j := i + n; j < size; j++ {
+		new = append(new, list.Get(types.Int(j)))
+	}
+	return types.NewRefValList(types.DefaultTypeAdapter, new)
+}
+
+// pointerAdd returns v with val added at the location referred to by tokens.
+func pointerAdd(v ref.Val, tokens []string, val ref.Val) (ref.Val, error) {
+	if len(tokens) == 0 {
+		return val, nil
+	}
+	return pointerUpdate(v, tokens, func(parent ref.Val, last string) (ref.Val, error) {
+		switch obj := parent.(type) {
+		case traits.Mapper:
+			return mapWith(obj, types.String(last), val), nil
+		case traits.Lister:
+			i, err := pointerIndex(obj, last, true)
+			if err != nil {
+				return nil, err
+			}
+			return listSplice(obj, i, 0, val), nil
+		default:
+			return nil, fmt.Errorf("cannot add %q to %s", last, parent.Type())
+		}
+	})
+}
+
+// pointerRemove returns v with the value referred to by tokens removed.
+func pointerRemove(v ref.Val, tokens []string) (ref.Val, error) {
+	if len(tokens) == 0 {
+		return nil, errors.New("cannot remove root")
+	}
+	return pointerUpdate(v, tokens, func(parent ref.Val, last string) (ref.Val, error) {
+		switch obj := parent.(type) {
+		case traits.Mapper:
+			if _, ok := obj.Find(types.String(last)); !ok {
+				return nil, fmt.Errorf("no such key: %q", last)
+			}
+			return mapWithout(obj, types.String(last)), nil
+		case traits.Lister:
+			i, err := pointerIndex(obj, last, false)
+			if err != nil {
+				return nil, err
+			}
+			return listSplice(obj, i, 1), nil
+		default:
+			return nil, fmt.Errorf("cannot remove %q from %s", last, parent.Type())
+		}
+	})
+}
+
+// pointerReplace returns v with the value referred to by tokens replaced
+// by val.
+func pointerReplace(v ref.Val, tokens []string, val ref.Val) (ref.Val, error) {
+	if len(tokens) == 0 {
+		return val, nil
+	}
+	return pointerUpdate(v, tokens, func(parent ref.Val, last string) (ref.Val, error) {
+		switch obj := parent.(type) {
+		case traits.Mapper:
+			if _, ok := obj.Find(types.String(last)); !ok {
+				return nil, fmt.Errorf("no such key: %q", last)
+			}
+			return mapWith(obj, types.String(last), val), nil
+		case traits.Lister:
+			i, err := pointerIndex(obj, last, false)
+			if err != nil {
+				return nil, err
+			}
+			return listSplice(obj, i, 1, val), nil
+		default:
+			return nil, fmt.Errorf("cannot replace %q in %s", last, parent.Type())
+		}
+	})
+}
+
+// applyJSONPatch returns v with the RFC 6902 JSON patch operations in patch
+// applied in order.
+func applyJSONPatch(v ref.Val, patch traits.Lister) (ref.Val, error) {
+	it := patch.Iterator()
+	for i := 0; it.HasNext() == types.True; i++ {
+		op, ok := it.Next().(traits.Mapper)
+		if !ok {
+			return nil, fmt.Errorf("operation %d: not an object", i)
+		}
+		var err error
+		v, err = applyJSONPatchOp(v, op)
+		if err != nil {
+			return nil, fmt.Errorf("operation %d: %w", i, err)
+		}
+	}
+	return v, nil
+}
+
+func applyJSONPatchOp(v ref.Val, op traits.Mapper) (ref.Val, error) {
+	str := func(name string) (string, error) {
+		f, ok := op.Find(types.String(name))
+		if !ok {
+			return "", fmt.Errorf("missing %s", name)
+		}
+		s, ok := f.(types.String)
+		if !ok {
+			return "", fmt.Errorf("invalid %s type: %s", name, f.Type())
+		}
+		return string(s), nil
+	}
+	pointer := func(name string) ([]string, error) {
+		p, err := str(name)
+		if err != nil {
+			return nil, err
+		}
+		return parsePointer(p)
+	}
+	value := func() (ref.Val, error) {
+		val, ok := op.Find(types.String("value"))
+		if !ok {
+			return nil, errors.New("missing value")
+		}
+		return val, nil
+	}
+
+	name, err := str("op")
+	if err != nil {
+		return nil, err
+	}
+	path, err := pointer("path")
+	if err != nil {
+		return nil, err
+	}
+	switch name {
+	case "add":
+		val, err := value()
+		if err != nil {
+			return nil, err
+		}
+		return pointerAdd(v, path, val)
+	case "remove":
+		return pointerRemove(v, path)
+	case "replace":
+		val, err := value()
+		if err != nil {
+			return nil, err
+		}
+		return pointerReplace(v, path, val)
+	case "move", "copy":
+		from, err := pointer("from")
+		if err != nil {
+			return nil, err
+		}
+		val, err := pointerGet(v, from)
+		if err != nil {
+			return nil, err
+		}
+		if name == "move" {
+			if isPointerPrefix(from, path) && len(from) != len(path) {
+				return nil, errors.New("cannot move a value into itself")
+			}
+			v, err = pointerRemove(v, from)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return pointerAdd(v, path, val)
+	case "test":
+		val, err := value()
+		if err != nil {
+			return nil, err
+		}
+		got, err := pointerGet(v, path)
+		if err != nil {
+			return nil, err
+		}
+		if types.Equal(got, val) != types.True {
+			return nil, errors.New("test failed")
+		}
+		return v, nil
+	default:
+		return nil, fmt.Errorf("invalid op: %q", name)
+	}
+}
+
+// isPointerPrefix returns whether prefix is a prefix of tokens.
+func isPointerPrefix(prefix, tokens []string) bool {
+	if len(prefix) > len(tokens) {
+		return false
+	}
+	for i, t := range prefix {
+		if tokens[i] != t {
+			return false
+		}
+	}
+	return true
+}
+
+// diffJSONPatch returns a list of RFC 6902 JSON patch operations that
+// transform a into b.
+func diffJSONPatch(a, b ref.Val) []ref.Val {
+	var ops []ref.Val
+	op := func(name, path string, val ref.Val) {
+		m := map[ref.Val]ref.Val{
+			types.String("op"):   types.String(name),
+			types.String("path"): types.String(path),
+		}
+		if val != nil {
+			m[types.String("value")] = val
+		}
+		ops = append(ops, types.NewRefValMap(types.DefaultTypeAdapter, m))
+	}
+	var diff func(path string, a, b ref.Val)
+	diff = func(path string, a, b ref.Val) {
+		switch a := a.(type) {
+		case traits.Mapper:
+			b, ok := b.(traits.Mapper)
+			if !ok {
+				break
+			}
+			for _, k := range sortedKeys(a) {
+				bv, ok := b.Find(k)
+				if !ok {
+					op("remove", path+"/"+pointerToken(k), nil)
+					continue
+				}
+				diff(path+"/"+pointerToken(k), a.Get(k), bv)
+			}
+			for _, k := range sortedKeys(b) {
+				if _, ok := a.Find(k); !ok {
+					op("add", path+"/"+pointerToken(k), b.Get(k))
+				}
+			}
+			return
+		case traits.Lister:
+			b, ok := b.(traits.Lister)
+			if !ok {
+				break
+			}
+			na := int(a.Size().(types.Int))
+			nb := int(b.Size().(types.Int))
+			for i := 0; i < na && i < nb; i++ {
+				diff(path+"/"+strconv.Itoa(i), a.Get(types.Int(i)), b.Get(types.Int(i)))
+			}
+			for i := na; i < nb; i++ {
+				op("add", path+"/"+strconv.Itoa(i), b.Get(types.Int(i)))
+			}
+			for i := na - 1; i >= nb; i-- {
+				op("remove", path+"/"+strconv.Itoa(i), nil)
+			}
+			return
+		}
+		if a.Type() != b.Type() || types.Equal(a, b) != types.True {
+			op("replace", path, b)
+		}
+	}
+	diff("", a, b)
+	return ops
+}
+
+// applyMergePatch returns v with the RFC 7396 JSON merge patch applied.
+func applyMergePatch(v, patch ref.Val) ref.Val {
+	p, ok := patch.(traits.Mapper)
+	if !ok {
+		return patch
+	}
+	new := make(map[ref.Val]ref.Val)
+	if m, ok := v.(traits.Mapper); ok {
+		it := m.Iterator()
+		for it.HasNext() == types.True {
+			k := it.Next()
+			new[k] = m.Get(k)
+		}
+	}
+	it := p.Iterator()
+	for it.HasNext() == types.True {
+		k := it.Next()
+		pv := p.Get(k)
+		if pv == types.NullValue {
+			delete(new, k)
+			continue
+		}
+		new[k] = applyMergePatch(new[k], pv)
+	}
+	return types.NewRefValMap(types.DefaultTypeAdapter, new)
+}
+
+// diffMergePatch returns an RFC 7396 JSON merge patch that transforms a
+// into b.
+func diffMergePatch(a, b ref.Val) ref.Val {
+	am, ok := a.(traits.Mapper)
+	if !ok {
+		return b
+	}
+	bm, ok := b.(traits.Mapper)
+	if !ok {
+		return b
+	}
+	patch := make(map[ref.Val]ref.Val)
+	it := am.Iterator()
+	for it.HasNext() == types.True {
+		k := it.Next()
+		if _, ok := bm.Find(k); !ok {
+			patch[k] = types.NullValue
+		}
+	}
+	it = bm.Iterator()
+	for it.HasNext() == types.True {
+		k := it.Next()
+		bv := bm.Get(k)
+		av, ok := am.Find(k)
+		if !ok {
+			patch[k] = bv
+			continue
+		}
+		if av.Type() == bv.Type() && types.Equal(av, bv) == types.True {
+			continue
+		}
+		patch[k] = diffMergePatch(av, bv)
+	}
+	return types.NewRefValMap(types.DefaultTypeAdapter, patch)
+}
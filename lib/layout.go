@@ -0,0 +1,334 @@
+package lib
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// strftimeLayouts is the translation of strftime conversion specifications
+// to Go time layout elements.
+var strftimeLayouts = map[string]string{
+	"%a":  "Mon",
+	"%A":  "Monday",
+	"%b":  "Jan",
+	"%B":  "January",
+	"%c":  "Mon Jan _2 15:04:05 2006",
+	"%d":  "02",
+	"%-d": "2",
+	"%D":  "01/02/06",
+	"%e":  "_2",
+	"%F":  "2006-01-02",
+	"%h":  "Jan",
+	"%H":  "15",
+	"%I":  "03",
+	"%-I": "3",
+	"%j":  "002",
+	"%m":  "01",
+	"%-m": "1",
+	"%M":  "04",
+	"%-M": "4",
+	"%n":  "\n",
+	"%p":  "PM",
+	"%R":  "15:04",
+	"%S":  "05",
+	"%-S": "5",
+	"%t":  "\t",
+	"%T":  "15:04:05",
+	"%x":  "01/02/06",
+	"%X":  "15:04:05",
+	"%y":  "06",
+	"%Y":  "2006",
+	"%z":  "-0700",
+	"%:z": "-07:00",
+	"%Z":  "MST",
+	"%%":  "%",
+}
+
+// strftimeToLayout returns the Go time layout equivalent to the strftime
+// format f. The %f directive for fractional seconds must follow a '.' or
+// ',' and gives microsecond precision.
+func strftimeToLayout(f string) (string, error) {
+	var (
+		parts   []layoutPart
+		literal strings.Builder
+	)
+	flush := func() {
+		if literal.Len() != 0 {
+			parts = append(parts, layoutPart{text: literal.String()})
+			literal.Reset()
+		}
+	}
+	for i := 0; i < len(f); i++ {
+		if f[i] != '%' {
+			literal.WriteByte(f[i])
+			continue
+		}
+		if i+1 == len(f) {
+			return "", fmt.Errorf("incomplete directive at end of %q", f)
+		}
+		spec := f[i : i+2]
+		if (f[i+1] == '-' || f[i+1] == ':') && i+2 < len(f) {
+			spec = f[i : i+3]
+		}
+		i += len(spec) - 1
+		if spec == "%%" {
+			literal.WriteByte('%')
+			continue
+		}
+		flush()
+		if spec == "%f" {
+			if !endsWithFractionSeparator(parts) {
+				return "", fmt.Errorf("%%f must follow '.' or ',' in %q", f)
+			}
+			parts = append(parts, layoutPart{text: "000000", elem: true})
+			continue
+		}
+		elem, ok := strftimeLayouts[spec]
+		if !ok {
+			return "", fmt.Errorf("unsupported strftime directive: %s", spec)
+		}
+		parts = append(parts, layoutPart{text: elem, elem: true})
+	}
+	flush()
+	return joinLayout(parts, f)
+}
+
+// javaToLayout returns the Go time layout equivalent to the Java
+// DateTimeFormatter pattern p. Fractional seconds must follow a '.'
+// or ','.
+func javaToLayout(p string) (string, error) {
+	var (
+		parts   []layoutPart
+		literal strings.Builder
+	)
+	flush := func() {
+		if literal.Len() != 0 {
+			parts = append(parts, layoutPart{text: literal.String()})
+			literal.Reset()
+		}
+	}
+	for i := 0; i < len(p); {
+		c := p[i]
+		switch {
+		case c == '\'':
+			// Quoted literal text, with '' representing a
+			// single quote both inside and outside quotes.
+			if i+1 < len(p) && p[i+1] == '\'' {
+				literal.WriteByte('\'')
+				i += 2
+				continue
+			}
+			i++
+			for {
+				if i == len(p) {
+					return "", fmt.Errorf("unterminated quote in %q", p)
+				}
+				if p[i] == '\'' {
+					if i+1 < len(p) && p[i+1] == '\'' {
+						literal.WriteByte('\'')
+						i += 2
+						continue
+					}
+					i++
+					break
+				}
+				literal.WriteByte(p[i])
+				i++
+			}
+			continue
+		case ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z'):
+		default:
+			literal.WriteByte(c)
+			i++
+			continue
+		}
+
+		n := 1
+		for i+n < len(p) && p[i+n] == c {
+			n++
+		}
+		i += n
+		flush()
+		if c == 'S' {
+			if !endsWithFractionSeparator(parts) {
+				return "", fmt.Errorf("fractional seconds must follow '.' or ',' in %q", p)
+			}
+			parts = append(parts, layoutPart{text: strings.Repeat("0", n), elem: true})
+			continue
+		}
+		elem := javaLayoutElement(c, n)
+		if elem == "" {
+			return "", fmt.Errorf("unsupported pattern: %s", strings.Repeat(string(c), n))
+		}
+		parts = append(parts, layoutPart{text: elem, elem: true})
+	}
+	flush()
+	return joinLayout(parts, p)
+}
+
+// javaLayoutElement returns the Go time layout element for a run of n
+// copies of the Java DateTimeFormatter pattern letter c, or the empty
+// string if there is no equivalent.
+func javaLayoutElement(c byte, n int) string {
+	switch c {
+	case 'y', 'u':
+		switch n {
+		case 2:
+			return "06"
+		case 1, 4:
+			return "2006"
+		}
+	case 'M', 'L':
+		switch n {
+		case 1:
+			return "1"
+		case 2:
+			return "01"
+		case 3:
+			return "Jan"
+		case 4:
+			return "January"
+		}
+	case 'd':
+		switch n {
+		case 1:
+			return "2"
+		case 2:
+			return "02"
+		}
+	case 'D':
+		if n == 3 {
+			return "002"
+		}
+	case 'H':
+		// Go has no unpadded 24-hour element, but parsing
+		// accepts one or two digits.
+		if n <= 2 {
+			return "15"
+		}
+	case 'h':
+		switch n {
+		case 1:
+			return "3"
+		case 2:
+			return "03"
+		}
+	case 'm':
+		switch n {
+		case 1:
+			return "4"
+		case 2:
+			return "04"
+		}
+	case 's':
+		switch n {
+		case 1:
+			return "5"
+		case 2:
+			return "05"
+		}
+	case 'a':
+		if n == 1 {
+			return "PM"
+		}
+	case 'E':
+		switch {
+		case n <= 3:
+			return "Mon"
+		case n == 4:
+			return "Monday"
+		}
+	case 'z':
+		if n <= 3 {
+			return "MST"
+		}
+	case 'Z':
+		switch {
+		case n <= 3:
+			return "-0700"
+		case n == 5:
+			return "-07:00"
+		}
+	case 'X':
+		switch n {
+		case 1:
+			return "Z07"
+		case 2, 4:
+			return "Z0700"
+		case 3, 5:
+			return "Z07:00"
+		}
+	case 'x':
+		switch n {
+		case 1:
+			return "-07"
+		case 2, 4:
+			return "-0700"
+		case 3, 5:
+			return "-07:00"
+		}
+	}
+	return ""
+}
+
+// layoutPart is a component of a Go time layout under construction. It is
+// either a layout element or literal text.
+type layoutPart struct {
+	text string
+	elem bool
+}
+
+// endsWithFractionSeparator returns whether the last part is literal text
+// ending with a fractional seconds separator.
+func endsWithFractionSeparator(parts []layoutPart) bool {
+	if len(parts) == 0 || parts[len(parts)-1].elem {
+		return false
+	}
+	text := parts[len(parts)-1].text
+	return strings.HasSuffix(text, ".") || strings.HasSuffix(text, ",")
+}
+
+// layoutCheckTimes are the times used to check that a layout is
+// interpreted by the time package as intended. They differ in every
+// field so that any misinterpreted element changes the formatted text.
+var layoutCheckTimes = []time.Time{
+	time.Date(2009, time.November, 17, 20, 34, 58, 651387237, time.FixedZone("XYZ", 5*3600+30*60)),
+	time.Date(2022, time.March, 4, 7, 8, 9, 12345678, time.FixedZone("ABC", -3*3600)),
+}
+
+// joinLayout returns the Go time layout made from parts. The time package
+// has no escape mechanism and tokenises the complete layout, so literal
+// text that would be interpreted as part of a layout element, either on
+// its own or together with adjacent text, cannot be represented and
+// results in an error. This is detected by checking that the layout
+// formats times in the same way as its parts do individually. The src
+// parameter is the format being translated and is used in errors.
+func joinLayout(parts []layoutPart, src string) (string, error) {
+	var b strings.Builder
+	for _, p := range parts {
+		b.WriteString(p.text)
+	}
+	layout := b.String()
+	for _, t := range layoutCheckTimes {
+		var want strings.Builder
+		for i, p := range parts {
+			switch {
+			case !p.elem:
+				want.WriteString(p.text)
+			case strings.Trim(p.text, "0") == "":
+				// Fractional seconds are only an element when
+				// they follow a separator, which is always the
+				// last byte of the preceding literal part.
+				sep := parts[i-1].text[len(parts[i-1].text)-1:]
+				want.WriteString(t.Format(sep + p.text)[1:])
+			default:
+				want.WriteString(t.Format(p.text))
+			}
+		}
+		if t.Format(layout) != want.String() {
+			return "", fmt.Errorf("literal text in %q cannot be represented in a Go layout", src)
+		}
+	}
+	return layout, nil
+}
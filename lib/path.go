@@ -0,0 +1,800 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/google/cel-go/common/types"
+	"github.com/google/cel-go/common/types/ref"
+	"github.com/google/cel-go/common/types/traits"
+)
+
+// Field paths are described in the documentation for Collections.
+
+// segmentKind is the kind of a path segment.
+type segmentKind int
+
+const (
+	keySegment segmentKind = iota
+	indexSegment
+	wildcardSegment
+)
+
+// pathSegment is a single step of a field path.
+type pathSegment struct {
+	kind  segmentKind
+	key   string
+	index int
+
+	// recursive indicates that the segment is
+	// applied at every depth below the current
+	// node as well as to the node itself.
+	recursive bool
+}
+
+func (s pathSegment) String() string {
+	var prefix string
+	if s.recursive {
+		prefix = ".."
+	}
+	switch s.kind {
+	case keySegment:
+		return prefix + escapePathKey(s.key)
+	case indexSegment:
+		return prefix + "[" + strconv.Itoa(s.index) + "]"
+	case wildcardSegment:
+		return prefix + "[*]"
+	default:
+		panic("invalid segment kind")
+	}
+}
+
+// parsePath returns the segments described by path. The empty path
+// has no segments and addresses the root value.
+func parsePath(path string) ([]pathSegment, error) {
+	var (
+		segs []pathSegment
+		pos  int
+	)
+	for pos < len(path) {
+		var recursive bool
+		switch {
+		case strings.HasPrefix(path[pos:], ".."):
+			recursive = true
+			pos += 2
+		case path[pos] == '.':
+			if pos == 0 {
+				return nil, errors.New("path begins with separator")
+			}
+			pos++
+		case path[pos] == '[':
+		default:
+			if pos != 0 {
+				return nil, fmt.Errorf("missing separator at offset %d", pos)
+			}
+		}
+		if pos == len(path) {
+			return nil, errors.New("path ends with separator")
+		}
+		var (
+			seg pathSegment
+			err error
+		)
+		if path[pos] == '[' {
+			seg, pos, err = parseBracket(path, pos)
+		} else {
+			seg, pos, err = parseKey(path, pos)
+		}
+		if err != nil {
+			return nil, err
+		}
+		seg.recursive = recursive
+		segs = append(segs, seg)
+	}
+	return segs, nil
+}
+
+// parseKey parses an unquoted key or wildcard segment starting at pos.
+func parseKey(path string, pos int) (pathSegment, int, error) {
+	if path[pos] == '*' && (pos+1 == len(path) || path[pos+1] == '.' || path[pos+1] == '[') {
+		return pathSegment{kind: wildcardSegment}, pos + 1, nil
+	}
+	var key strings.Builder
+	for ; pos < len(path); pos++ {
+		switch c := path[pos]; c {
+		case '.', '[':
+			if key.Len() == 0 {
+				return pathSegment{}, pos, fmt.Errorf("empty key at offset %d", pos)
+			}
+			return pathSegment{kind: keySegment, key: key.String()}, pos, nil
+		case '\\':
+			pos++
+			if pos == len(path) {
+				return pathSegment{}, pos, errors.New("path ends with escape")
+			}
+			key.WriteByte(path[pos])
+		default:
+			key.WriteByte(c)
+		}
+	}
+	return pathSegment{kind: keySegment, key: key.String()}, pos, nil
+}
+
+// parseBracket parses a bracketed index, wildcard or quoted key segment
+// starting at pos.
+func parseBracket(path string, pos int) (pathSegment, int, error) {
+	start := pos
+	pos++ // Skip '['.
+	if pos == len(path) {
+		return pathSegment{}, pos, fmt.Errorf("unterminated bracket at offset %d", start)
+	}
+	switch q := path[pos]; q {
+	case '"', '\'':
+		var key strings.Builder
+		for pos++; pos < len(path); pos++ {
+			switch c := path[pos]; c {
+			case q:
+				pos++
+				if pos == len(path) || path[pos] != ']' {
+					return pathSegment{}, pos, fmt.Errorf("unterminated bracket at offset %d", start)
+				}
+				return pathSegment{kind: keySegment, key: key.String()}, pos + 1, nil
+			case '\\':
+				pos++
+				if pos == len(path) {
+					return pathSegment{}, pos, errors.New("path ends with escape")
+				}
+				key.WriteByte(path[pos])
+			default:
+				key.WriteByte(c)
+			}
+		}
+		return pathSegment{}, pos, fmt.Errorf("unterminated quoted key at offset %d", start)
+	default:
+		end := strings.IndexByte(path[pos:], ']')
+		if end < 0 {
+			return pathSegment{}, pos, fmt.Errorf("unterminated bracket at offset %d", start)
+		}
+		end += pos
+		text := path[pos:end]
+		if text == "*" {
+			return pathSegment{kind: wildcardSegment}, end + 1, nil
+		}
+		idx, err := strconv.Atoi(text)
+		if err != nil {
+			return pathSegment{}, pos, fmt.Errorf("invalid index at offset %d: %q", start, text)
+		}
+		return pathSegment{kind: indexSegment, index: idx}, end + 1, nil
+	}
+}
+
+// escapePathKey returns key escaped so that it is interpreted as a single
+// key segment when used in a path.
+func escapePathKey(key string) string {
+	switch key {
+	case "":
+		return `[""]`
+	case "*":
+		return `\*`
+	}
+	if !strings.ContainsAny(key, `.[\`) {
+		return key
+	}
+	var buf strings.Builder
+	for i := 0; i < len(key); i++ {
+		switch key[i] {
+		case '.', '[', '\\':
+			buf.WriteByte('\\')
+		}
+		buf.WriteByte(key[i])
+	}
+	return buf.String()
+}
+
+// joinPath returns the path string for segs.
+func joinPath(segs []pathSegment) string {
+	var buf strings.Builder
+	for i, s := range segs {
+		str := s.String()
+		if i != 0 && !strings.HasPrefix(str, ".") && !strings.HasPrefix(str, "[") {
+			buf.WriteByte('.')
+		}
+		buf.WriteString(str)
+	}
+	return buf.String()
+}
+
+// pathLookup returns the values in v that are addressed by path.
+func pathLookup(v ref.Val, path []pathSegment) []ref.Val {
+	if len(path) == 0 {
+		return []ref.Val{v}
+	}
+	seg := path[0]
+	if seg.recursive {
+		direct := seg
+		direct.recursive = false
+		vals := applySegment(v, direct, path[1:], false)
+		for _, c := range children(v) {
+			vals = append(vals, pathLookup(c, path)...)
+		}
+		return vals
+	}
+	return applySegment(v, seg, path[1:], true)
+}
+
+// applySegment returns the values addressed by seg followed by rest in v.
+// If distribute is true, key segments are applied to each element of a
+// list.
+func applySegment(v ref.Val, seg pathSegment, rest []pathSegment, distribute bool) []ref.Val {
+	var vals []ref.Val
+	switch seg.kind {
+	case keySegment:
+		switch obj := v.(type) {
+		case traits.Mapper:
+			if val, ok := obj.Find(types.String(seg.key)); ok {
+				vals = pathLookup(val, rest)
+			}
+		case traits.Lister:
+			if !distribute {
+				break
+			}
+			it := obj.Iterator()
+			for it.HasNext() == types.True {
+				vals = append(vals, applySegment(it.Next(), seg, rest, true)...)
+			}
+		}
+	case indexSegment:
+		list, ok := v.(traits.Lister)
+		if !ok {
+			break
+		}
+		if i, ok := listIndex(list, seg.index); ok {
+			vals = pathLookup(list.Get(types.Int(i)), rest)
+		}
+	case wildcardSegment:
+		for _, c := range children(v) {
+			vals = append(vals, pathLookup(c, rest)...)
+		}
+	}
+	return vals
+}
+
+// pathDelete returns v with the values addressed by path removed. The
+// returned bool indicates whether any value was removed; if it is false,
+// v is returned unaltered.
+func pathDelete(v ref.Val, path []pathSegment) (ref.Val, bool) {
+	if len(path) == 0 {
+		return v, false
+	}
+	seg := path[0]
+	if !seg.recursive {
+		return deleteSegment(v, seg, path[1:], true)
+	}
+	direct := seg
+	direct.recursive = false
+	v, changed := deleteSegment(v, direct, path[1:], false)
+	v, ok := replaceChildren(v, func(c ref.Val) (ref.Val, bool, bool) {
+		c, changed := pathDelete(c, path)
+		return c, true, changed
+	})
+	return v, changed || ok
+}
+
+// deleteSegment returns v with the values addressed by seg followed by rest
+// removed. If distribute is true, key segments are applied to each element
+// of a list.
+func deleteSegment(v ref.Val, seg pathSegment, rest []pathSegment, distribute bool) (ref.Val, bool) {
+	switch seg.kind {
+	case keySegment:
+		switch obj := v.(type) {
+		case traits.Mapper:
+			key := types.String(seg.key)
+			val, ok := obj.Find(key)
+			if !ok {
+				return v, false
+			}
+			if len(rest) == 0 {
+				return mapWithout(obj, key), true
+			}
+			val, changed := pathDelete(val, rest)
+			if !changed {
+				return v, false
+			}
+			return mapWith(obj, key, val), true
+		case traits.Lister:
+			if !distribute {
+				return v, false
+			}
+			return replaceChildren(v, func(c ref.Val) (ref.Val, bool, bool) {
+				c, changed := deleteSegment(c, seg, rest, true)
+				return c, true, changed
+			})
+		}
+	case indexSegment:
+		list, ok := v.(traits.Lister)
+		if !ok {
+			return v, false
+		}
+		idx, ok := listIndex(list, seg.index)
+		if !ok {
+			return v, false
+		}
+		var i int
+		return replaceChildren(v, func(c ref.Val) (ref.Val, bool, bool) {
+			defer func() { i++ }()
+			if i != idx {
+				return c, true, false
+			}
+			if len(rest) == 0 {
+				return nil, false, true
+			}
+			c, changed := pathDelete(c, rest)
+			return c, true, changed
+		})
+	case wildcardSegment:
+		return replaceChildren(v, func(c ref.Val) (ref.Val, bool, bool) {
+			if len(rest) == 0 {
+				return nil, false, true
+			}
+			c, changed := pathDelete(c, rest)
+			return c, true, changed
+		})
+	}
+	return v, false
+}
+
+// replaceChildren returns v with each child of v replaced by the result
+// of calling fn on the child. The keep result of fn indicates whether the
+// child is retained and the changed result indicates whether it differs
+// from the original. If no child is changed, v is returned unaltered.
+func replaceChildren(v ref.Val, fn func(ref.Val) (val ref.Val, keep, changed bool)) (ref.Val, bool) {
+	switch obj := v.(type) {
+	case traits.Mapper:
+		var changed bool
+		new := make(map[ref.Val]ref.Val)
+		it := obj.Iterator()
+		for it.HasNext() == types.True {
+			k := it.Next()
+			val, keep, ok := fn(obj.Get(k))
+			changed = changed || ok
+			if keep {
+				new[k] = val
+			}
+		}
+		if !changed {
+			return v, false
+		}
+		return types.NewRefValMap(types.DefaultTypeAdapter, new), true
+	case traits.Lister:
+		var changed bool
+		new := make([]ref.Val, 0, int(obj.Size().(types.Int)))
+		it := obj.Iterator()
+		for it.HasNext() == types.True {
+			val, keep, ok := fn(it.Next())
+			changed = changed || ok
+			if keep {
+				new = append(new, val)
+			}
+		}
+		if !changed {
+			return v, false
+		}
+		return types.NewRefValList(types.DefaultTypeAdapter, new), true
+	default:
+		return v, false
+	}
+}
+
+// children returns the values held by a map, in key order, or list.
+func children(v ref.Val) []ref.Val {
+	switch obj := v.(type) {
+	case traits.Mapper:
+		keys := sortedKeys(obj)
+		vals := make([]ref.Val, len(keys))
+		for i, k := range keys {
+			vals[i] = obj.Get(k)
+		}
+		return vals
+	case traits.Lister:
+		var vals []ref.Val
+		it := obj.Iterator()
+		for it.HasNext() == types.True {
+			vals = append(vals, it.Next())
+		}
+		return vals
+	default:
+		return nil
+	}
+}
+
+// sortedKeys returns the keys of m in a deterministic order.
+func sortedKeys(m traits.Mapper) []ref.Val {
+	var keys []ref.Val
+	it := m.Iterator()
+	for it.HasNext() == types.True {
+		keys = append(keys, it.Next())
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.Type() != b.Type() {
+			return a.Type().TypeName() < b.Type().TypeName()
+		}
+		if a, ok := a.(traits.Comparer); ok {
+			return a.Compare(b) == types.IntNegOne
+		}
+		return false
+	})
+	return keys
+}
+
+// listIndex returns the absolute index into list for idx, which may be
+// negative to index from the end of the list, and whether it is in range.
+func listIndex(list traits.Lister, idx int) (int, bool) {
+	n := int(list.Size().(types.Int))
+	if idx < 0 {
+		idx += n
+	}
+	return idx, 0 <= idx && idx < n
+}
+
+// mapWith returns a copy of m with key set to val.
+func mapWith(m traits.Mapper, key, val ref.Val) ref.Val {
+	new := make(map[ref.Val]ref.Val)
+	it := m.Iterator()
+	for it.HasNext() == types.True {
+		k := it.Next()
+		new[k] = m.Get(k)
+	}
+	new[key] = val
+	return types.NewRefValMap(types.DefaultTypeAdapter, new)
+}
+
+// mapWithout returns a copy of m without key.
+func mapWithout(m traits.Mapper, key ref.Val) ref.Val {
+	new := make(map[ref.Val]ref.Val)
+	it := m.Iterator()
+	for it.HasNext() == types.True {
+		k := it.Next()
+		if k.Equal(key) == types.True {
+			continue
+		}
+		new[k] = m.Get(k)
+	}
+	return types.NewRefValMap(types.DefaultTypeAdapter, new)
+}
+
+// pathKeep returns v with only the values addressed by paths retained.
+// The returned bool indicates whether any value was addressed. Elements
+// of lists that are reached by applying a key segment to the list are
+// retained as projections even when no value within them is addressed,
+// so that the positions of records in a list are preserved.
+func pathKeep(v ref.Val, paths [][]pathSegment) (ref.Val, bool) {
+	for _, p := range paths {
+		if len(p) == 0 {
+			return v, true
+		}
+	}
+	switch obj := v.(type) {
+	case traits.Mapper:
+		var matched bool
+		new := make(map[ref.Val]ref.Val)
+		it := obj.Iterator()
+		for it.HasNext() == types.True {
+			k := it.Next()
+			var sub [][]pathSegment
+			for _, p := range paths {
+				seg := p[0]
+				if seg.recursive {
+					sub = append(sub, p)
+				}
+				switch seg.kind {
+				case keySegment:
+					if k.Equal(types.String(seg.key)) == types.True {
+						sub = append(sub, p[1:])
+					}
+				case wildcardSegment:
+					sub = append(sub, p[1:])
+				}
+			}
+			if len(sub) == 0 {
+				continue
+			}
+			val, ok := pathKeep(obj.Get(k), sub)
+			if ok {
+				new[k] = val
+				matched = true
+			}
+		}
+		return types.NewRefValMap(types.DefaultTypeAdapter, new), matched
+
+	case traits.Lister:
+		var matched bool
+		new := make([]ref.Val, 0, int(obj.Size().(types.Int)))
+		n := int(obj.Size().(types.Int))
+		for i := 0; i < n; i++ {
+			var (
+				sub         [][]pathSegment
+				distributed bool
+			)
+			for _, p := range paths {
+				seg := p[0]
+				if seg.recursive {
+					sub = append(sub, p)
+					distributed = true
+				}
+				switch seg.kind {
+				case keySegment:
+					if !seg.recursive {
+						sub = append(sub, p)
+						distributed = true
+					}
+				case indexSegment:
+					if idx, ok := listIndex(obj, seg.index); ok && idx == i {
+						sub = append(sub, p[1:])
+					}
+				case wildcardSegment:
+					sub = append(sub, p[1:])
+				}
+			}
+			if len(sub) == 0 {
+				continue
+			}
+			elem := obj.Get(types.Int(i))
+			val, ok := pathKeep(elem, sub)
+			if ok {
+				matched = true
+			} else if _, container := elem.(iterator); !container || !distributed {
+				continue
+			}
+			new = append(new, val)
+		}
+		return types.NewRefValList(types.DefaultTypeAdapter, new), matched
+
+	default:
+		return v, false
+	}
+}
+
+// checkSinglePath returns an error if path may address more than a
+// single value.
+func checkSinglePath(path []pathSegment) error {
+	for _, s := range path {
+		if s.recursive {
+			return errors.New("recursive descent not allowed")
+		}
+		if s.kind == wildcardSegment {
+			return errors.New("wildcard not allowed")
+		}
+	}
+	return nil
+}
+
+// pathGet returns the single value at path in v and whether it exists.
+// Key segments are only applied to maps and index segments only to lists.
+func pathGet(v ref.Val, path []pathSegment) (ref.Val, bool) {
+	for _, seg := range path {
+		switch seg.kind {
+		case keySegment:
+			m, ok := v.(traits.Mapper)
+			if !ok {
+				return nil, false
+			}
+			v, ok = m.Find(types.String(seg.key))
+			if !ok {
+				return nil, false
+			}
+		case indexSegment:
+			l, ok := v.(traits.Lister)
+			if !ok {
+				return nil, false
+			}
+			i, ok := listIndex(l, seg.index)
+			if !ok {
+				return nil, false
+			}
+			v = l.Get(types.Int(i))
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// pathResolve returns the single value at path in v and whether it exists,
+// as for pathGet, and the path with negative indexes replaced by their
+// absolute positions.
+func pathResolve(v ref.Val, path []pathSegment) ([]pathSegment, ref.Val, bool) {
+	resolved := make([]pathSegment, len(path))
+	copy(resolved, path)
+	for i, seg := range resolved {
+		if seg.kind == indexSegment {
+			l, ok := v.(traits.Lister)
+			if !ok {
+				return nil, nil, false
+			}
+			resolved[i].index, ok = listIndex(l, seg.index)
+			if !ok {
+				return nil, nil, false
+			}
+		}
+		var ok bool
+		v, ok = pathGet(v, resolved[i:i+1])
+		if !ok {
+			return nil, nil, false
+		}
+	}
+	return resolved, v, true
+}
+
+// comparePaths returns a negative number, zero or a positive number
+// depending on whether the non-recursive path a sorts before, equal to or
+// after b. Index segments are ordered numerically and sort after key
+// segments. A path sorts before any path that it is a prefix of.
+func comparePaths(a, b []pathSegment) int {
+	for i := 0; i < len(a) && i < len(b); i++ {
+		sa, sb := a[i], b[i]
+		if sa.kind != sb.kind {
+			return int(sa.kind) - int(sb.kind)
+		}
+		switch sa.kind {
+		case keySegment:
+			if c := strings.Compare(sa.key, sb.key); c != 0 {
+				return c
+			}
+		case indexSegment:
+			if sa.index != sb.index {
+				if sa.index < sb.index {
+					return -1
+				}
+				return 1
+			}
+		}
+	}
+	return len(a) - len(b)
+}
+
+// pathSet returns v with the value at path set to val. Missing maps on the
+// path are created. A nil v indicates that the value does not exist.
+func pathSet(v ref.Val, path []pathSegment, val ref.Val) (ref.Val, error) {
+	if len(path) == 0 {
+		return val, nil
+	}
+	seg := path[0]
+	switch seg.kind {
+	case keySegment:
+		key := types.String(seg.key)
+		switch obj := v.(type) {
+		case nil:
+			child, err := pathSet(nil, path[1:], val)
+			if err != nil {
+				return nil, err
+			}
+			return types.NewRefValMap(types.DefaultTypeAdapter, map[ref.Val]ref.Val{key: child}), nil
+		case traits.Mapper:
+			child, ok := obj.Find(key)
+			if !ok {
+				child = nil
+			}
+			child, err := pathSet(child, path[1:], val)
+			if err != nil {
+				return nil, err
+			}
+			return mapWith(obj, key, child), nil
+		default:
+			return nil, fmt.Errorf("cannot set key %q in %s", seg.key, v.Type().TypeName())
+		}
+	case indexSegment:
+		list, ok := v.(traits.Lister)
+		if !ok {
+			if v == nil {
+				return nil, fmt.Errorf("cannot set index %d of missing list", seg.index)
+			}
+			return nil, fmt.Errorf("cannot set index %d in %s", seg.index, v.Type().TypeName())
+		}
+		idx, ok := listIndex(list, seg.index)
+		if !ok {
+			return nil, fmt.Errorf("index out of range: %d", seg.index)
+		}
+		n := int(list.Size().(types.Int))
+		new := make([]ref.Val, n)
+		for i := range new {
+			new[i] = list.Get(types.Int(i))
+		}
+		child, err := pathSet(new[idx], path[1:], val)
+		if err != nil {
+			return nil, err
+		}
+		new[idx] = child
+		return types.NewRefValList(types.DefaultTypeAdapter, new), nil
+	default:
+		return nil, errors.New("invalid path segment")
+	}
+}
+
+// escapeFlatKey returns key with backslash, '[' and sep escaped with a
+// backslash for use as a component of a flattened key.
+func escapeFlatKey(key, sep string) string {
+	if !strings.ContainsAny(key, `[\`) && !strings.Contains(key, sep) {
+		return key
+	}
+	var buf strings.Builder
+	for i := 0; i < len(key); {
+		switch {
+		case key[i] == '\\', key[i] == '[':
+			buf.WriteByte('\\')
+			buf.WriteByte(key[i])
+			i++
+		case strings.HasPrefix(key[i:], sep):
+			buf.WriteByte('\\')
+			buf.WriteString(sep)
+			i += len(sep)
+		default:
+			buf.WriteByte(key[i])
+			i++
+		}
+	}
+	return buf.String()
+}
+
+// parseFlatKey returns the path segments of a flattened key. Components
+// of the key are separated by sep and list indexes are bracketed integers
+// following a component.
+func parseFlatKey(key, sep string) ([]pathSegment, error) {
+	var (
+		segs []pathSegment
+		comp strings.Builder
+	)
+	for i := 0; ; {
+		if i == len(key) || strings.HasPrefix(key[i:], sep) {
+			segs = append(segs, pathSegment{kind: keySegment, key: comp.String()})
+			comp.Reset()
+			if i == len(key) {
+				return segs, nil
+			}
+			i += len(sep)
+			continue
+		}
+		switch key[i] {
+		case '\\':
+			i++
+			if i == len(key) {
+				return nil, errors.New("key ends with escape")
+			}
+			if strings.HasPrefix(key[i:], sep) {
+				comp.WriteString(sep)
+				i += len(sep)
+			} else {
+				comp.WriteByte(key[i])
+				i++
+			}
+		case '[':
+			segs = append(segs, pathSegment{kind: keySegment, key: comp.String()})
+			comp.Reset()
+			for i < len(key) && key[i] == '[' {
+				end := strings.IndexByte(key[i:], ']')
+				if end < 0 {
+					return nil, fmt.Errorf("unterminated bracket at offset %d", i)
+				}
+				idx, err := strconv.Atoi(key[i+1 : i+end])
+				if err != nil || idx < 0 {
+					return nil, fmt.Errorf("invalid index at offset %d: %q", i, key[i+1:i+end])
+				}
+				segs = append(segs, pathSegment{kind: indexSegment, index: idx})
+				i += end + 1
+			}
+			if i == len(key) {
+				return segs, nil
+			}
+			if !strings.HasPrefix(key[i:], sep) {
+				return nil, fmt.Errorf("missing separator at offset %d", i)
+			}
+			i += len(sep)
+		default:
+			comp.WriteByte(key[i])
+			i++
+		}
+	}
+}
@@ -1,8 +1,13 @@
 package lib
 
 import (
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
+	_ "time/tzdata" // Embed time zone data for location support.
 
 	"github.com/google/cel-go/cel"
 	"github.com/google/cel-go/checker/decls"
@@ -41,29 +46,341 @@ import (
 // Format
 //
 // Returns a string representation of the timestamp formatted according to
-// the provided layout:
+// the provided layout. If a location is provided, the timestamp is formatted
+// in that location:
 //
 //     <timestamp>.format(<string>) -> <string>
+//     <timestamp>.format(<string>, <string>) -> <string>
 //
 // Examples:
 //
-//     now().format(time_layout.Kitchen)  // return "11:17AM"
+//     now().format(time_layout.Kitchen)                                   // return "11:17AM"
+//     timestamp("2022-03-30T11:17:57Z").format(time_layout.RFC3339, "Australia/Adelaide")
+//                                                                         // return "2022-03-30T21:47:57+10:30"
+//
+//
+// In Location
+//
+// Returns the timestamp in the named IANA time zone location. The instant
+// in time is unchanged, but subsequent formatting uses the location's offset.
+// The location names "UTC" and "Local" are also accepted:
+//
+//     <timestamp>.in_location(<string>) -> <timestamp>
+//
+// Examples:
+//
+//     timestamp("2022-03-30T11:17:57Z").in_location("Australia/Adelaide").format(time_layout.RFC3339)
+//                                                                         // return "2022-03-30T21:47:57+10:30"
+//     now().in_location("Mars/Olympus_Mons")                              // return error
 //
 //
 // Parse Time
 //
 // Returns a timestamp from a string based on a time layout or list of possible
 // layouts. If a list of formats is provided, the first successful layout is
-// used:
+// used. If a location is provided, times without a zone offset are interpreted
+// as being in that location, otherwise they are interpreted as UTC:
 //
 //     <string>.parse_time(<string>) -> <timestamp>
 //     <string>.parse_time(<list<string>>) -> <timestamp>
+//     <string>.parse_time(<string>, <string>) -> <timestamp>
+//     <string>.parse_time(<list<string>>, <string>) -> <timestamp>
 //
 // Examples:
 //
 //     "11:17AM".parse_time(time_layout.Kitchen)                       // return <timestamp>
 //     "11:17AM".parse_time([time_layout.RFC3339,time_layout.Kitchen]) // return <timestamp>
 //     "11:17AM".parse_time(time_layout.RFC3339)                       // return error
+//     "2022-03-30 21:47:57".parse_time("2006-01-02 15:04:05", "Australia/Adelaide")
+//                                                                     // return "2022-03-30T21:47:57+10:30"
+//
+//
+// Parse Time Auto
+//
+// Returns a map holding the timestamp parsed from a string in one of a set of
+// common formats and the detected layout. The formats are RFC 3339 and ISO 8601
+// variants including the basic format, RFC 1123, RFC 850, RFC 822, ANSIC, Unix
+// and Ruby date formats, BSD syslog timestamps, Unix epoch seconds, optionally
+// with a fractional part, milliseconds, microseconds and nanoseconds, and
+// Windows FILETIME values. Epoch values are distinguished by their number of
+// digits. The layout is a Go time layout that can be used with parse_time, or
+// "unix", "unix_millis", "unix_micros", "unix_nanos" or "windows_filetime" for
+// epoch values. Times without a zone offset are interpreted as UTC, or as being
+// in the location given by the optional parameter. BSD syslog timestamps have
+// no year, so the year is chosen to place the time no later than one day after
+// the time of the call:
+//
+//     <string>.parse_time_auto() -> <map<string,dyn>>
+//     <string>.parse_time_auto(<string>) -> <map<string,dyn>>
+//
+// Examples:
+//
+//     "2022-03-30T11:17:57.123Z".parse_time_auto()
+//                              // return {"timestamp": "2022-03-30T11:17:57.123Z", "layout": "2006-01-02T15:04:05Z07:00"}
+//     "1650094960123".parse_time_auto()
+//                              // return {"timestamp": "2022-04-16T07:42:40.123Z", "layout": "unix_millis"}
+//     "Mar 30 11:17:57".parse_time_auto().layout
+//                              // return "Jan _2 15:04:05"
+//     "tomorrow".parse_time_auto()
+//                              // return error
+//
+//
+// Unix
+//
+// Returns the number of seconds, milliseconds, microseconds or nanoseconds
+// since the Unix epoch for the timestamp. The int forms truncate toward
+// negative infinity and the double forms include the fractional part:
+//
+//     <timestamp>.unix() -> <int>
+//     <timestamp>.unix_millis() -> <int>
+//     <timestamp>.unix_micros() -> <int>
+//     <timestamp>.unix_nanos() -> <int>
+//     <timestamp>.unix_double() -> <double>
+//     <timestamp>.unix_millis_double() -> <double>
+//     <timestamp>.unix_micros_double() -> <double>
+//
+// Examples:
+//
+//     timestamp("2022-04-16T07:42:40.123Z").unix()                // return 1650094960
+//     timestamp("2022-04-16T07:42:40.123Z").unix_millis()         // return 1650094960123
+//     timestamp("2022-04-16T07:42:40.123Z").unix_double()         // return 1650094960.123
+//     timestamp("2022-04-16T07:42:40.123456Z").unix_millis_double()  // return 1650094960123.456
+//
+//
+// From Unix
+//
+// Returns the timestamp for a number of seconds, milliseconds, microseconds
+// or nanoseconds since the Unix epoch. Fractional values are accepted as
+// doubles and are rounded to the nearest nanosecond:
+//
+//     <int>.from_unix() -> <timestamp>
+//     <double>.from_unix() -> <timestamp>
+//     <int>.from_unix_millis() -> <timestamp>
+//     <double>.from_unix_millis() -> <timestamp>
+//     <int>.from_unix_micros() -> <timestamp>
+//     <double>.from_unix_micros() -> <timestamp>
+//     <int>.from_unix_nanos() -> <timestamp>
+//     <double>.from_unix_nanos() -> <timestamp>
+//
+// Examples:
+//
+//     int(1650094960123).from_unix_millis()  // return "2022-04-16T07:42:40.123Z"
+//     1650094960.5.from_unix()               // return "2022-04-16T07:42:40.5Z"
+//
+//
+// Truncate
+//
+// Returns the timestamp rounded down to a multiple of the duration since the
+// zero time, or to the start of the calendar unit containing the timestamp.
+// Calendar units are "second", "minute", "hour", "day", "week", "month" and
+// "year", with weeks starting on Monday. Calendar truncation is performed in
+// the timestamp's location, or in the location given by the optional second
+// parameter:
+//
+//     <timestamp>.truncate(<duration>) -> <timestamp>
+//     <timestamp>.truncate(<string>) -> <timestamp>
+//     <timestamp>.truncate(<string>, <string>) -> <timestamp>
+//
+// Examples:
+//
+//     timestamp("2022-04-16T07:42:40Z").truncate(duration("15m"))  // return "2022-04-16T07:30:00Z"
+//     timestamp("2022-04-16T07:42:40Z").truncate("month")          // return "2022-04-01T00:00:00Z"
+//     timestamp("2022-04-16T07:42:40Z").truncate("week")           // return "2022-04-11T00:00:00Z"
+//     timestamp("2022-04-16T07:42:40Z").truncate("day", "Australia/Adelaide")
+//                                                                  // return "2022-04-16T00:00:00+09:30"
+//
+//
+// Round
+//
+// Returns the timestamp rounded to the nearest multiple of the duration since
+// the zero time, with halfway values rounded up:
+//
+//     <timestamp>.round(<duration>) -> <timestamp>
+//
+// Examples:
+//
+//     timestamp("2022-04-16T07:42:40Z").round(duration("1h"))  // return "2022-04-16T08:00:00Z"
+//
+//
+// Add Date
+//
+// Returns the timestamp with the given numbers of years, months and days
+// added in the timestamp's location. Values outside their usual ranges are
+// normalized, so adding one month to October 31 gives December 1:
+//
+//     <timestamp>.add_date(<int>, <int>, <int>) -> <timestamp>
+//
+// Examples:
+//
+//     timestamp("2022-04-16T07:42:40Z").add_date(0, -1, 0)  // return "2022-03-16T07:42:40Z"
+//     timestamp("2022-01-31T00:00:00Z").add_date(0, 1, 0)   // return "2022-03-03T00:00:00Z"
+//
+//
+// Between
+//
+// Returns the calendar difference between two timestamps as the numbers of
+// whole years, months and days and the remaining duration, such that adding
+// the years, months and days to the first timestamp with add_date and then
+// adding the duration gives the second timestamp. The calculation is performed
+// in the first timestamp's location. If the second timestamp is before the
+// first, the difference from the second to the first is returned negated:
+//
+//     between(<timestamp>, <timestamp>) -> <map<string,dyn>>
+//
+// Examples:
+//
+//     between(timestamp("2022-01-15T00:00:00Z"), timestamp("2023-03-20T06:00:00Z"))
+//                              // return {"years": 1, "months": 2, "days": 5, "duration": duration("6h")}
+//
+//
+// Day of Week
+//
+// Returns the ISO 8601 day of the week of the timestamp in its location, with
+// Monday as 1 and Sunday as 7:
+//
+//     <timestamp>.day_of_week() -> <int>
+//
+// Examples:
+//
+//     timestamp("2022-04-17T07:42:40Z").day_of_week()  // return 7
+//
+//
+// Day of Year
+//
+// Returns the day of the year of the timestamp in its location, starting
+// from 1:
+//
+//     <timestamp>.day_of_year() -> <int>
+//
+// Examples:
+//
+//     timestamp("2022-02-01T07:42:40Z").day_of_year()  // return 32
+//
+//
+// ISO Week
+//
+// Returns the ISO 8601 year and week number of the timestamp in its location:
+//
+//     <timestamp>.iso_week() -> <map<string,int>>
+//
+// Examples:
+//
+//     timestamp("2022-01-01T07:42:40Z").iso_week()  // return {"year": 2021, "week": 52}
+//
+//
+// Start and End of Month
+//
+// Returns the first or last instant of the month containing the timestamp in
+// its location. The end of a month is the last nanosecond before the start of
+// the next month:
+//
+//     <timestamp>.start_of_month() -> <timestamp>
+//     <timestamp>.end_of_month() -> <timestamp>
+//
+// Examples:
+//
+//     timestamp("2022-04-16T07:42:40Z").start_of_month()  // return "2022-04-01T00:00:00Z"
+//     timestamp("2022-04-16T07:42:40Z").end_of_month()    // return "2022-04-30T23:59:59.999999999Z"
+//
+//     Last full month:
+//
+//     now.start_of_month().add_date(0, -1, 0).as(start, [start, start.end_of_month()])
+//
+//
+// Strftime Layout
+//
+// Returns the Go time layout equivalent to a strftime format for use with
+// format and parse_time. The %f directive gives six digits of fractional
+// seconds and must follow a '.' or ','. Literal text that would be interpreted
+// as part of a Go layout on its own or together with an adjacent directive, such
+// as digits or "uary" after %b, cannot be represented and results in an error:
+//
+//     <string>.strftime_layout() -> <string>
+//
+// Examples:
+//
+//     "%Y-%m-%dT%H:%M:%S.%f%z".strftime_layout()  // return "2006-01-02T15:04:05.000000-0700"
+//     now().format("%d %b %Y".strftime_layout())   // return "30 Mar 2022"
+//
+//
+// Java Layout
+//
+// Returns the Go time layout equivalent to a Java DateTimeFormatter pattern for
+// use with format and parse_time. Fractional seconds must follow a '.' or ','
+// and are formatted and parsed with the number of digits given by the pattern.
+// Literal text that would be interpreted as part of a Go layout on its own or
+// together with an adjacent pattern, such as digits or "uary" after MMM,
+// cannot be represented and results in an error:
+//
+//     <string>.java_layout() -> <string>
+//
+// Examples:
+//
+//     "yyyy-MM-dd'T'HH:mm:ss.SSSXXX".java_layout()  // return "2006-01-02T15:04:05.000Z07:00"
+//     "30/03/2022".parse_time("dd/MM/yyyy".java_layout())
+//                                                   // return "2022-03-30T00:00:00Z"
+//
+//
+// Format Human
+//
+// Returns a string representation of the duration as days, hours, minutes
+// and seconds, omitting zero components. A day is 24 hours:
+//
+//     <duration>.format_human() -> <string>
+//
+// Examples:
+//
+//     duration("26h3m4.5s").format_human()  // return "1 day 2 hours 3 minutes 4.5 seconds"
+//     duration("-90s").format_human()       // return "-1 minute 30 seconds"
+//     duration("0s").format_human()         // return "0 seconds"
+//
+//
+// Format ISO 8601
+//
+// Returns the ISO 8601 representation of the duration using days, hours,
+// minutes and seconds. A day is 24 hours. Negative durations are prefixed
+// with a minus sign:
+//
+//     <duration>.format_iso8601() -> <string>
+//
+// Examples:
+//
+//     duration("26h3m4.5s").format_iso8601()  // return "P1DT2H3M4.5S"
+//     duration("-90s").format_iso8601()       // return "-PT1M30S"
+//     duration("0s").format_iso8601()         // return "PT0S"
+//
+//
+// Parse ISO 8601 Duration
+//
+// Returns the duration represented by an ISO 8601 duration string. Years,
+// months, weeks and days are taken to be 365, 30, 7 and 1 days long, with a
+// day being 24 hours. Only the last component may have a fractional part.
+// A leading sign is accepted:
+//
+//     <string>.parse_iso8601_duration() -> <duration>
+//
+// Examples:
+//
+//     "P1DT2H".parse_iso8601_duration()   // return "93600s"
+//     "PT1.5S".parse_iso8601_duration()   // return "1.5s"
+//     "P1Y".parse_iso8601_duration()      // return "31536000s"
+//     "1 day".parse_iso8601_duration()    // return error
+//
+//
+// In Hours, Minutes and Seconds
+//
+// Returns the duration as a floating point number of hours, minutes or
+// seconds:
+//
+//     <duration>.in_hours() -> <double>
+//     <duration>.in_minutes() -> <double>
+//     <duration>.in_seconds() -> <double>
+//
+// Examples:
+//
+//     duration("90m").in_hours()     // return 1.5
+//     duration("90s").in_minutes()   // return 1.5
+//     duration("1500ms").in_seconds() // return 1.5
 //
 //
 // Global Variables
@@ -92,6 +409,9 @@ import (
 //         "StampNano":   time.StampNano,
 //         "HTTP":        http.TimeFormat
 //     }
+//
+// Time zone data is embedded, so locations are available even when the
+// system has no time zone database.
 func Time() cel.EnvOption {
 	return cel.Lib(timeLib{})
 }
@@ -116,6 +436,18 @@ func (timeLib) CompileOptions() []cel.EnvOption {
 					[]*expr.Type{decls.Timestamp, decls.String},
 					decls.String,
 				),
+				decls.NewInstanceOverload(
+					"timestamp_format_string_string",
+					[]*expr.Type{decls.Timestamp, decls.String, decls.String},
+					decls.String,
+				),
+			),
+			decls.NewFunction("in_location",
+				decls.NewInstanceOverload(
+					"timestamp_in_location_string",
+					[]*expr.Type{decls.Timestamp, decls.String},
+					decls.Timestamp,
+				),
 			),
 			decls.NewFunction("parse_time",
 				decls.NewInstanceOverload(
@@ -128,108 +460,1096 @@ func (timeLib) CompileOptions() []cel.EnvOption {
 					[]*expr.Type{decls.String, decls.NewListType(decls.String)},
 					decls.Timestamp,
 				),
+				decls.NewInstanceOverload(
+					"string_parse_time_string_string",
+					[]*expr.Type{decls.String, decls.String, decls.String},
+					decls.Timestamp,
+				),
+				decls.NewInstanceOverload(
+					"string_parse_time_list_string_string",
+					[]*expr.Type{decls.String, decls.NewListType(decls.String), decls.String},
+					decls.Timestamp,
+				),
 			),
-		),
-	}
-}
-
-func (timeLib) ProgramOptions() []cel.ProgramOption {
-	return []cel.ProgramOption{
-		cel.Globals(map[string]interface{}{
-			"now": func() interface{} { return time.Now().In(time.UTC) },
-			"time_layout": map[string]string{
-				"Layout":      time.Layout,
-				"ANSIC":       time.ANSIC,
-				"UnixDate":    time.UnixDate,
-				"RubyDate":    time.RubyDate,
-				"RFC822":      time.RFC822,
-				"RFC822Z":     time.RFC822Z,
-				"RFC850":      time.RFC850,
-				"RFC1123":     time.RFC1123,
-				"RFC1123Z":    time.RFC1123Z,
-				"RFC3339":     time.RFC3339,
-				"RFC3339Nano": time.RFC3339Nano,
-				"Kitchen":     time.Kitchen,
-				"Stamp":       time.Stamp,
-				"StampMilli":  time.StampMilli,
-				"StampMicro":  time.StampMicro,
-				"StampNano":   time.StampNano,
-				"HTTP":        http.TimeFormat,
-			},
-		}),
-		cel.Functions(
-			&functions.Overload{
-				Operator: "now_void",
-				Function: now,
-			},
-			&functions.Overload{
-				Operator: "timestamp_format_string",
-				Binary:   formatTime,
-			},
-			&functions.Overload{
-				Operator: "string_parse_time_string",
-				Binary:   parseTimeWithLayout,
-			},
-			&functions.Overload{
-				Operator: "string_parse_time_list_string",
-				Binary:   parseTimeWithLayouts,
-			},
-		),
-	}
-}
-
-func now(args ...ref.Val) ref.Val {
-	if len(args) != 0 {
-		return types.NewErr("no such overload")
-	}
-	return types.Timestamp{Time: time.Now().In(time.UTC)}
-}
-
-func formatTime(arg, layout ref.Val) ref.Val {
-	obj, ok := arg.(types.Timestamp)
-	if !ok {
-		return types.ValOrErr(obj, "no such overload for time layout: %s", arg.Type())
-	}
-	l, ok := layout.(types.String)
-	if !ok {
-		return types.ValOrErr(l, "no such overload for time layout: %s", layout.Type())
-	}
-	return types.String(obj.Format(string(l)))
-}
-
-func parseTimeWithLayout(arg, layout ref.Val) ref.Val {
-	obj, ok := arg.(types.String)
-	if !ok {
-		return types.ValOrErr(obj, "no such overload for time layout: %s", arg.Type())
-	}
-	l, ok := layout.(types.String)
-	if !ok {
-		return types.ValOrErr(l, "no such overload for time layout: %s", layout.Type())
-	}
-	t, err := time.Parse(string(l), string(obj))
-	if err != nil {
-		return types.NewErr("failed %v", err)
-	}
-	return types.Timestamp{Time: t}
-}
-
-func parseTimeWithLayouts(arg, layout ref.Val) ref.Val {
-	obj, ok := arg.(types.String)
-	if !ok {
-		return types.ValOrErr(obj, "no such overload for time layout: %s", arg.Type())
-	}
-	layouts, ok := layout.(traits.Lister)
-	if !ok {
-		return types.ValOrErr(layouts, "no such overload for time layout: %s", layout.Type())
-	}
-	it := layouts.Iterator()
-	for it.HasNext() == types.True {
-		l := it.Next().(types.String)
-		t, err := time.Parse(string(l), string(obj))
-		if err != nil {
-			continue
-		}
-		return types.Timestamp{Time: t}
-	}
-	return types.NewErr("failed to parse %s with any provided layout", obj)
+			decls.NewFunction("unix",
+				decls.NewInstanceOverload(
+					"timestamp_unix",
+					[]*expr.Type{decls.Timestamp},
+					decls.Int,
+				),
+			),
+			decls.NewFunction("unix_millis",
+				decls.NewInstanceOverload(
+					"timestamp_unix_millis",
+					[]*expr.Type{decls.Timestamp},
+					decls.Int,
+				),
+			),
+			decls.NewFunction("unix_micros",
+				decls.NewInstanceOverload(
+					"timestamp_unix_micros",
+					[]*expr.Type{decls.Timestamp},
+					decls.Int,
+				),
+			),
+			decls.NewFunction("unix_nanos",
+				decls.NewInstanceOverload(
+					"timestamp_unix_nanos",
+					[]*expr.Type{decls.Timestamp},
+					decls.Int,
+				),
+			),
+			decls.NewFunction("unix_double",
+				decls.NewInstanceOverload(
+					"timestamp_unix_double",
+					[]*expr.Type{decls.Timestamp},
+					decls.Double,
+				),
+			),
+			decls.NewFunction("unix_millis_double",
+				decls.NewInstanceOverload(
+					"timestamp_unix_millis_double",
+					[]*expr.Type{decls.Timestamp},
+					decls.Double,
+				),
+			),
+			decls.NewFunction("unix_micros_double",
+				decls.NewInstanceOverload(
+					"timestamp_unix_micros_double",
+					[]*expr.Type{decls.Timestamp},
+					decls.Double,
+				),
+			),
+			decls.NewFunction("truncate",
+				decls.NewInstanceOverload(
+					"timestamp_truncate_duration",
+					[]*expr.Type{decls.Timestamp, decls.Duration},
+					decls.Timestamp,
+				),
+				decls.NewInstanceOverload(
+					"timestamp_truncate_string",
+					[]*expr.Type{decls.Timestamp, decls.String},
+					decls.Timestamp,
+				),
+				decls.NewInstanceOverload(
+					"timestamp_truncate_string_string",
+					[]*expr.Type{decls.Timestamp, decls.String, decls.String},
+					decls.Timestamp,
+				),
+			),
+			decls.NewFunction("round",
+				decls.NewInstanceOverload(
+					"timestamp_round_duration",
+					[]*expr.Type{decls.Timestamp, decls.Duration},
+					decls.Timestamp,
+				),
+			),
+			decls.NewFunction("add_date",
+				decls.NewInstanceOverload(
+					"timestamp_add_date_int_int_int",
+					[]*expr.Type{decls.Timestamp, decls.Int, decls.Int, decls.Int},
+					decls.Timestamp,
+				),
+			),
+			decls.NewFunction("between",
+				decls.NewOverload(
+					"between_timestamp_timestamp",
+					[]*expr.Type{decls.Timestamp, decls.Timestamp},
+					decls.NewMapType(decls.String, decls.Dyn),
+				),
+			),
+			decls.NewFunction("day_of_week",
+				decls.NewInstanceOverload(
+					"timestamp_day_of_week",
+					[]*expr.Type{decls.Timestamp},
+					decls.Int,
+				),
+			),
+			decls.NewFunction("day_of_year",
+				decls.NewInstanceOverload(
+					"timestamp_day_of_year",
+					[]*expr.Type{decls.Timestamp},
+					decls.Int,
+				),
+			),
+			decls.NewFunction("iso_week",
+				decls.NewInstanceOverload(
+					"timestamp_iso_week",
+					[]*expr.Type{decls.Timestamp},
+					decls.NewMapType(decls.String, decls.Int),
+				),
+			),
+			decls.NewFunction("start_of_month",
+				decls.NewInstanceOverload(
+					"timestamp_start_of_month",
+					[]*expr.Type{decls.Timestamp},
+					decls.Timestamp,
+				),
+			),
+			decls.NewFunction("end_of_month",
+				decls.NewInstanceOverload(
+					"timestamp_end_of_month",
+					[]*expr.Type{decls.Timestamp},
+					decls.Timestamp,
+				),
+			),
+			decls.NewFunction("parse_time_auto",
+				decls.NewInstanceOverload(
+					"string_parse_time_auto",
+					[]*expr.Type{decls.String},
+					decls.NewMapType(decls.String, decls.Dyn),
+				),
+				decls.NewInstanceOverload(
+					"string_parse_time_auto_string",
+					[]*expr.Type{decls.String, decls.String},
+					decls.NewMapType(decls.String, decls.Dyn),
+				),
+			),
+			decls.NewFunction("strftime_layout",
+				decls.NewInstanceOverload(
+					"string_strftime_layout",
+					[]*expr.Type{decls.String},
+					decls.String,
+				),
+			),
+			decls.NewFunction("java_layout",
+				decls.NewInstanceOverload(
+					"string_java_layout",
+					[]*expr.Type{decls.String},
+					decls.String,
+				),
+			),
+			decls.NewFunction("format_human",
+				decls.NewInstanceOverload(
+					"duration_format_human",
+					[]*expr.Type{decls.Duration},
+					decls.String,
+				),
+			),
+			decls.NewFunction("format_iso8601",
+				decls.NewInstanceOverload(
+					"duration_format_iso8601",
+					[]*expr.Type{decls.Duration},
+					decls.String,
+				),
+			),
+			decls.NewFunction("parse_iso8601_duration",
+				decls.NewInstanceOverload(
+					"string_parse_iso8601_duration",
+					[]*expr.Type{decls.String},
+					decls.Duration,
+				),
+			),
+			decls.NewFunction("in_hours",
+				decls.NewInstanceOverload(
+					"duration_in_hours",
+					[]*expr.Type{decls.Duration},
+					decls.Double,
+				),
+			),
+			decls.NewFunction("in_minutes",
+				decls.NewInstanceOverload(
+					"duration_in_minutes",
+					[]*expr.Type{decls.Duration},
+					decls.Double,
+				),
+			),
+			decls.NewFunction("in_seconds",
+				decls.NewInstanceOverload(
+					"duration_in_seconds",
+					[]*expr.Type{decls.Duration},
+					decls.Double,
+				),
+			),
+			decls.NewFunction("from_unix",
+				decls.NewInstanceOverload(
+					"int_from_unix",
+					[]*expr.Type{decls.Int},
+					decls.Timestamp,
+				),
+				decls.NewInstanceOverload(
+					"double_from_unix",
+					[]*expr.Type{decls.Double},
+					decls.Timestamp,
+				),
+			),
+			decls.NewFunction("from_unix_millis",
+				decls.NewInstanceOverload(
+					"int_from_unix_millis",
+					[]*expr.Type{decls.Int},
+					decls.Timestamp,
+				),
+				decls.NewInstanceOverload(
+					"double_from_unix_millis",
+					[]*expr.Type{decls.Double},
+					decls.Timestamp,
+				),
+			),
+			decls.NewFunction("from_unix_micros",
+				decls.NewInstanceOverload(
+					"int_from_unix_micros",
+					[]*expr.Type{decls.Int},
+					decls.Timestamp,
+				),
+				decls.NewInstanceOverload(
+					"double_from_unix_micros",
+					[]*expr.Type{decls.Double},
+					decls.Timestamp,
+				),
+			),
+			decls.NewFunction("from_unix_nanos",
+				decls.NewInstanceOverload(
+					"int_from_unix_nanos",
+					[]*expr.Type{decls.Int},
+					decls.Timestamp,
+				),
+				decls.NewInstanceOverload(
+					"double_from_unix_nanos",
+					[]*expr.Type{decls.Double},
+					decls.Timestamp,
+				),
+			),
+		),
+	}
+}
+
+func (timeLib) ProgramOptions() []cel.ProgramOption {
+	return []cel.ProgramOption{
+		cel.Globals(map[string]interface{}{
+			"now": func() interface{} { return time.Now().In(time.UTC) },
+			"time_layout": map[string]string{
+				"Layout":      time.Layout,
+				"ANSIC":       time.ANSIC,
+				"UnixDate":    time.UnixDate,
+				"RubyDate":    time.RubyDate,
+				"RFC822":      time.RFC822,
+				"RFC822Z":     time.RFC822Z,
+				"RFC850":      time.RFC850,
+				"RFC1123":     time.RFC1123,
+				"RFC1123Z":    time.RFC1123Z,
+				"RFC3339":     time.RFC3339,
+				"RFC3339Nano": time.RFC3339Nano,
+				"Kitchen":     time.Kitchen,
+				"Stamp":       time.Stamp,
+				"StampMilli":  time.StampMilli,
+				"StampMicro":  time.StampMicro,
+				"StampNano":   time.StampNano,
+				"HTTP":        http.TimeFormat,
+			},
+		}),
+		cel.Functions(
+			&functions.Overload{
+				Operator: "now_void",
+				Function: now,
+			},
+			&functions.Overload{
+				Operator: "timestamp_format_string",
+				Binary:   formatTime,
+			},
+			&functions.Overload{
+				Operator: "string_parse_time_string",
+				Binary:   parseTimeWithLayout,
+			},
+			&functions.Overload{
+				Operator: "string_parse_time_list_string",
+				Binary:   parseTimeWithLayouts,
+			},
+			&functions.Overload{
+				Operator: "timestamp_format_string_string",
+				Function: formatTimeInLocation,
+			},
+			&functions.Overload{
+				Operator: "timestamp_in_location_string",
+				Binary:   inLocation,
+			},
+			&functions.Overload{
+				Operator: "string_parse_time_string_string",
+				Function: parseTimeWithLayoutInLocation,
+			},
+			&functions.Overload{
+				Operator: "string_parse_time_list_string_string",
+				Function: parseTimeWithLayoutsInLocation,
+			},
+		),
+		cel.Functions(
+			&functions.Overload{
+				Operator: "timestamp_truncate_duration",
+				Binary:   truncateTime,
+			},
+			&functions.Overload{
+				Operator: "timestamp_truncate_string",
+				Binary:   truncateTime,
+			},
+			&functions.Overload{
+				Operator: "timestamp_truncate_string_string",
+				Function: truncateTimeInLocation,
+			},
+			&functions.Overload{
+				Operator: "timestamp_round_duration",
+				Binary:   roundTime,
+			},
+		),
+		cel.Functions(
+			&functions.Overload{
+				Operator: "timestamp_add_date_int_int_int",
+				Function: addDate,
+			},
+			&functions.Overload{
+				Operator: "between_timestamp_timestamp",
+				Binary:   between,
+			},
+			&functions.Overload{
+				Operator: "timestamp_day_of_week",
+				Unary:    dayOfWeek,
+			},
+			&functions.Overload{
+				Operator: "timestamp_day_of_year",
+				Unary:    dayOfYear,
+			},
+			&functions.Overload{
+				Operator: "timestamp_iso_week",
+				Unary:    isoWeek,
+			},
+			&functions.Overload{
+				Operator: "timestamp_start_of_month",
+				Unary:    startOfMonth,
+			},
+			&functions.Overload{
+				Operator: "timestamp_end_of_month",
+				Unary:    endOfMonth,
+			},
+		),
+		cel.Functions(
+			&functions.Overload{
+				Operator: "string_parse_time_auto",
+				Unary: func(arg ref.Val) ref.Val {
+					return parseTimeAuto(arg, types.String("UTC"))
+				},
+			},
+			&functions.Overload{
+				Operator: "string_parse_time_auto_string",
+				Binary:   parseTimeAuto,
+			},
+		),
+		cel.Functions(
+			&functions.Overload{
+				Operator: "string_strftime_layout",
+				Unary:    translateLayout(strftimeToLayout),
+			},
+			&functions.Overload{
+				Operator: "string_java_layout",
+				Unary:    translateLayout(javaToLayout),
+			},
+		),
+		cel.Functions(
+			&functions.Overload{
+				Operator: "duration_format_human",
+				Unary:    formatDuration(formatHumanDuration),
+			},
+			&functions.Overload{
+				Operator: "duration_format_iso8601",
+				Unary:    formatDuration(formatISODuration),
+			},
+			&functions.Overload{
+				Operator: "string_parse_iso8601_duration",
+				Unary:    parseDuration,
+			},
+			&functions.Overload{
+				Operator: "duration_in_hours",
+				Unary:    durationIn(time.Hour),
+			},
+			&functions.Overload{
+				Operator: "duration_in_minutes",
+				Unary:    durationIn(time.Minute),
+			},
+			&functions.Overload{
+				Operator: "duration_in_seconds",
+				Unary:    durationIn(time.Second),
+			},
+		),
+		cel.Functions(
+			&functions.Overload{
+				Operator: "timestamp_unix",
+				Unary:    unixTime(time.Second),
+			},
+			&functions.Overload{
+				Operator: "timestamp_unix_millis",
+				Unary:    unixTime(time.Millisecond),
+			},
+			&functions.Overload{
+				Operator: "timestamp_unix_micros",
+				Unary:    unixTime(time.Microsecond),
+			},
+			&functions.Overload{
+				Operator: "timestamp_unix_nanos",
+				Unary:    unixTime(time.Nanosecond),
+			},
+			&functions.Overload{
+				Operator: "timestamp_unix_double",
+				Unary:    unixTimeDouble(time.Second),
+			},
+			&functions.Overload{
+				Operator: "timestamp_unix_millis_double",
+				Unary:    unixTimeDouble(time.Millisecond),
+			},
+			&functions.Overload{
+				Operator: "timestamp_unix_micros_double",
+				Unary:    unixTimeDouble(time.Microsecond),
+			},
+			&functions.Overload{
+				Operator: "int_from_unix",
+				Unary:    fromUnixTime(time.Second),
+			},
+			&functions.Overload{
+				Operator: "double_from_unix",
+				Unary:    fromUnixTime(time.Second),
+			},
+			&functions.Overload{
+				Operator: "int_from_unix_millis",
+				Unary:    fromUnixTime(time.Millisecond),
+			},
+			&functions.Overload{
+				Operator: "double_from_unix_millis",
+				Unary:    fromUnixTime(time.Millisecond),
+			},
+			&functions.Overload{
+				Operator: "int_from_unix_micros",
+				Unary:    fromUnixTime(time.Microsecond),
+			},
+			&functions.Overload{
+				Operator: "double_from_unix_micros",
+				Unary:    fromUnixTime(time.Microsecond),
+			},
+			&functions.Overload{
+				Operator: "int_from_unix_nanos",
+				Unary:    fromUnixTime(time.Nanosecond),
+			},
+			&functions.Overload{
+				Operator: "double_from_unix_nanos",
+				Unary:    fromUnixTime(time.Nanosecond),
+			},
+		),
+	}
+}
+
+func now(args ...ref.Val) ref.Val {
+	if len(args) != 0 {
+		return types.NewErr("no such overload")
+	}
+	return types.Timestamp{Time: time.Now().In(time.UTC)}
+}
+
+func formatTime(arg, layout ref.Val) ref.Val {
+	obj, ok := arg.(types.Timestamp)
+	if !ok {
+		return types.ValOrErr(obj, "no such overload for time layout: %s", arg.Type())
+	}
+	l, ok := layout.(types.String)
+	if !ok {
+		return types.ValOrErr(l, "no such overload for time layout: %s", layout.Type())
+	}
+	return types.String(obj.Format(string(l)))
+}
+
+func parseTimeWithLayout(arg, layout ref.Val) ref.Val {
+	obj, ok := arg.(types.String)
+	if !ok {
+		return types.ValOrErr(obj, "no such overload for time layout: %s", arg.Type())
+	}
+	l, ok := layout.(types.String)
+	if !ok {
+		return types.ValOrErr(l, "no such overload for time layout: %s", layout.Type())
+	}
+	t, err := time.Parse(string(l), string(obj))
+	if err != nil {
+		return types.NewErr("failed %v", err)
+	}
+	return types.Timestamp{Time: t}
+}
+
+func parseTimeWithLayouts(arg, layout ref.Val) ref.Val {
+	obj, ok := arg.(types.String)
+	if !ok {
+		return types.ValOrErr(obj, "no such overload for time layout: %s", arg.Type())
+	}
+	layouts, ok := layout.(traits.Lister)
+	if !ok {
+		return types.ValOrErr(layouts, "no such overload for time layout: %s", layout.Type())
+	}
+	it := layouts.Iterator()
+	for it.HasNext() == types.True {
+		l := it.Next().(types.String)
+		t, err := time.Parse(string(l), string(obj))
+		if err != nil {
+			continue
+		}
+		return types.Timestamp{Time: t}
+	}
+	return types.NewErr("failed to parse %s with any provided layout", obj)
+}
+
+func formatTimeInLocation(args ...ref.Val) ref.Val {
+	if len(args) != 3 {
+		return types.NoSuchOverloadErr()
+	}
+	obj, ok := args[0].(types.Timestamp)
+	if !ok {
+		return types.ValOrErr(obj, "no such overload for time layout: %s", args[0].Type())
+	}
+	l, ok := args[1].(types.String)
+	if !ok {
+		return types.ValOrErr(l, "no such overload for time layout: %s", args[1].Type())
+	}
+	loc, err := location(args[2])
+	if err != nil {
+		return err
+	}
+	return types.String(obj.In(loc).Format(string(l)))
+}
+
+func inLocation(arg, name ref.Val) ref.Val {
+	obj, ok := arg.(types.Timestamp)
+	if !ok {
+		return types.ValOrErr(obj, "no such overload for time location: %s", arg.Type())
+	}
+	loc, err := location(name)
+	if err != nil {
+		return err
+	}
+	return types.Timestamp{Time: obj.In(loc)}
+}
+
+func parseTimeWithLayoutInLocation(args ...ref.Val) ref.Val {
+	if len(args) != 3 {
+		return types.NoSuchOverloadErr()
+	}
+	obj, ok := args[0].(types.String)
+	if !ok {
+		return types.ValOrErr(obj, "no such overload for time layout: %s", args[0].Type())
+	}
+	l, ok := args[1].(types.String)
+	if !ok {
+		return types.ValOrErr(l, "no such overload for time layout: %s", args[1].Type())
+	}
+	loc, err := location(args[2])
+	if err != nil {
+		return err
+	}
+	t, perr := time.ParseInLocation(string(l), string(obj), loc)
+	if perr != nil {
+		return types.NewErr("failed %v", perr)
+	}
+	return types.Timestamp{Time: t}
+}
+
+func parseTimeWithLayoutsInLocation(args ...ref.Val) ref.Val {
+	if len(args) != 3 {
+		return types.NoSuchOverloadErr()
+	}
+	obj, ok := args[0].(types.String)
+	if !ok {
+		return types.ValOrErr(obj, "no such overload for time layout: %s", args[0].Type())
+	}
+	layouts, ok := args[1].(traits.Lister)
+	if !ok {
+		return types.ValOrErr(layouts, "no such overload for time layout: %s", args[1].Type())
+	}
+	loc, err := location(args[2])
+	if err != nil {
+		return err
+	}
+	it := layouts.Iterator()
+	for it.HasNext() == types.True {
+		l := it.Next().(types.String)
+		t, err := time.ParseInLocation(string(l), string(obj), loc)
+		if err != nil {
+			continue
+		}
+		return types.Timestamp{Time: t}
+	}
+	return types.NewErr("failed to parse %s with any provided layout", obj)
+}
+
+func truncateTime(arg, by ref.Val) ref.Val {
+	obj, ok := arg.(types.Timestamp)
+	if !ok {
+		return types.ValOrErr(obj, "no such overload for truncate: %s", arg.Type())
+	}
+	switch by := by.(type) {
+	case types.Duration:
+		return types.Timestamp{Time: obj.Truncate(by.Duration)}
+	case types.String:
+		t, err := truncateCalendar(obj.Time, string(by))
+		if err != nil {
+			return err
+		}
+		return types.Timestamp{Time: t}
+	default:
+		return types.ValOrErr(by, "no such overload for truncate: %s", by.Type())
+	}
+}
+
+func truncateTimeInLocation(args ...ref.Val) ref.Val {
+	if len(args) != 3 {
+		return types.NoSuchOverloadErr()
+	}
+	obj, ok := args[0].(types.Timestamp)
+	if !ok {
+		return types.ValOrErr(obj, "no such overload for truncate: %s", args[0].Type())
+	}
+	unit, ok := args[1].(types.String)
+	if !ok {
+		return types.ValOrErr(unit, "no such overload for truncate: %s", args[1].Type())
+	}
+	loc, err := location(args[2])
+	if err != nil {
+		return err
+	}
+	t, err := truncateCalendar(obj.In(loc), string(unit))
+	if err != nil {
+		return err
+	}
+	return types.Timestamp{Time: t}
+}
+
+// truncateCalendar returns t truncated to the start of the calendar unit
+// containing it in t's location.
+func truncateCalendar(t time.Time, unit string) (time.Time, ref.Val) {
+	year, month, day := t.Date()
+	loc := t.Location()
+	switch unit {
+	case "second":
+		return time.Date(year, month, day, t.Hour(), t.Minute(), t.Second(), 0, loc), nil
+	case "minute":
+		return time.Date(year, month, day, t.Hour(), t.Minute(), 0, 0, loc), nil
+	case "hour":
+		return time.Date(year, month, day, t.Hour(), 0, 0, 0, loc), nil
+	case "day":
+		return time.Date(year, month, day, 0, 0, 0, 0, loc), nil
+	case "week":
+		// Weeks start on Monday.
+		offset := (int(t.Weekday()) + 6) % 7
+		return time.Date(year, month, day-offset, 0, 0, 0, 0, loc), nil
+	case "month":
+		return time.Date(year, month, 1, 0, 0, 0, 0, loc), nil
+	case "year":
+		return time.Date(year, time.January, 1, 0, 0, 0, 0, loc), nil
+	default:
+		return time.Time{}, types.NewErr("invalid calendar unit: %s", unit)
+	}
+}
+
+func roundTime(arg, by ref.Val) ref.Val {
+	obj, ok := arg.(types.Timestamp)
+	if !ok {
+		return types.ValOrErr(obj, "no such overload for round: %s", arg.Type())
+	}
+	d, ok := by.(types.Duration)
+	if !ok {
+		return types.ValOrErr(d, "no such overload for round: %s", by.Type())
+	}
+	return types.Timestamp{Time: obj.Round(d.Duration)}
+}
+
+func addDate(args ...ref.Val) ref.Val {
+	if len(args) != 4 {
+		return types.NoSuchOverloadErr()
+	}
+	obj, ok := args[0].(types.Timestamp)
+	if !ok {
+		return types.ValOrErr(obj, "no such overload for add_date: %s", args[0].Type())
+	}
+	var n [3]int
+	for i, a := range args[1:] {
+		v, ok := a.(types.Int)
+		if !ok {
+			return types.ValOrErr(v, "no such overload for add_date: %s", a.Type())
+		}
+		n[i] = int(v)
+	}
+	return types.Timestamp{Time: obj.AddDate(n[0], n[1], n[2])}
+}
+
+func between(a, b ref.Val) ref.Val {
+	t1, ok := a.(types.Timestamp)
+	if !ok {
+		return types.ValOrErr(t1, "no such overload for between: %s", a.Type())
+	}
+	t2, ok := b.(types.Timestamp)
+	if !ok {
+		return types.ValOrErr(t2, "no such overload for between: %s", b.Type())
+	}
+	sign := 1
+	from, to := t1.Time, t2.In(t1.Location())
+	if to.Before(from) {
+		sign = -1
+		from, to = to, from
+	}
+
+	// Find the largest number of months and then days that
+	// can be added to from without passing to.
+	y1, m1, _ := from.Date()
+	y2, m2, _ := to.Date()
+	months := (y2-y1)*12 + int(m2-m1)
+	for months > 0 && from.AddDate(0, months, 0).After(to) {
+		months--
+	}
+	start := from.AddDate(0, months, 0)
+	days := int(to.Sub(start) / (24 * time.Hour))
+	for !start.AddDate(0, 0, days+1).After(to) {
+		days++
+	}
+	for days > 0 && start.AddDate(0, 0, days).After(to) {
+		days--
+	}
+	rem := to.Sub(start.AddDate(0, 0, days))
+
+	return types.NewStringInterfaceMap(types.DefaultTypeAdapter, map[string]interface{}{
+		"years":    sign * (months / 12),
+		"months":   sign * (months % 12),
+		"days":     sign * days,
+		"duration": time.Duration(sign) * rem,
+	})
+}
+
+func dayOfWeek(arg ref.Val) ref.Val {
+	obj, ok := arg.(types.Timestamp)
+	if !ok {
+		return types.ValOrErr(obj, "no such overload for day_of_week: %s", arg.Type())
+	}
+	return types.Int((obj.Weekday()+6)%7 + 1)
+}
+
+func dayOfYear(arg ref.Val) ref.Val {
+	obj, ok := arg.(types.Timestamp)
+	if !ok {
+		return types.ValOrErr(obj, "no such overload for day_of_year: %s", arg.Type())
+	}
+	return types.Int(obj.YearDay())
+}
+
+func isoWeek(arg ref.Val) ref.Val {
+	obj, ok := arg.(types.Timestamp)
+	if !ok {
+		return types.ValOrErr(obj, "no such overload for iso_week: %s", arg.Type())
+	}
+	year, week := obj.ISOWeek()
+	return types.NewStringInterfaceMap(types.DefaultTypeAdapter, map[string]interface{}{
+		"year": year,
+		"week": week,
+	})
+}
+
+func startOfMonth(arg ref.Val) ref.Val {
+	obj, ok := arg.(types.Timestamp)
+	if !ok {
+		return types.ValOrErr(obj, "no such overload for start_of_month: %s", arg.Type())
+	}
+	year, month, _ := obj.Date()
+	return types.Timestamp{Time: time.Date(year, month, 1, 0, 0, 0, 0, obj.Location())}
+}
+
+func endOfMonth(arg ref.Val) ref.Val {
+	obj, ok := arg.(types.Timestamp)
+	if !ok {
+		return types.ValOrErr(obj, "no such overload for end_of_month: %s", arg.Type())
+	}
+	year, month, _ := obj.Date()
+	return types.Timestamp{Time: time.Date(year, month+1, 1, 0, 0, 0, 0, obj.Location()).Add(-time.Nanosecond)}
+}
+
+// autoLayouts is the list of layouts tried by parse_time_auto in order.
+// Fractional seconds are accepted after seconds fields when parsing, so
+// layouts do not need to include them.
+var autoLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05Z0700",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02 15:04:05Z0700",
+	"2006-01-02 15:04:05 Z07:00",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02T15:04",
+	"2006-01-02",
+	"20060102T150405Z0700",
+	"20060102T150405",
+	"20060102",
+	time.RFC1123,
+	time.RFC1123Z,
+	time.RFC850,
+	time.RFC822,
+	time.RFC822Z,
+	time.ANSIC,
+	time.UnixDate,
+	time.RubyDate,
+	"Mon, _2 Jan 2006 15:04:05 MST",
+	"Mon, _2 Jan 2006 15:04:05 -0700",
+	"_2 Jan 2006 15:04:05 MST",
+	"_2 Jan 2006 15:04:05 -0700",
+	time.Stamp,
+}
+
+func parseTimeAuto(arg, name ref.Val) ref.Val {
+	obj, ok := arg.(types.String)
+	if !ok {
+		return types.ValOrErr(obj, "no such overload for time: %s", arg.Type())
+	}
+	loc, err := location(name)
+	if err != nil {
+		return err
+	}
+	s := strings.TrimSpace(string(obj))
+	t, layout, ok := parseEpoch(s)
+	if !ok {
+		for _, l := range autoLayouts {
+			var err error
+			t, err = time.ParseInLocation(l, s, loc)
+			if err != nil {
+				continue
+			}
+			layout = l
+			if l == time.Stamp {
+				t = syslogYear(t, time.Now().In(loc))
+			}
+			ok = true
+			break
+		}
+	}
+	if !ok {
+		return types.NewErr("failed to detect time format: %q", s)
+	}
+	return types.NewStringInterfaceMap(types.DefaultTypeAdapter, map[string]interface{}{
+		"timestamp": types.Timestamp{Time: t},
+		"layout":    layout,
+	})
+}
+
+// parseEpoch returns the time represented by s if it is a Unix epoch
+// value or Windows FILETIME, and the name of the detected format. The
+// format is determined by the number of integer digits in s. An eight
+// digit value is not considered to be an epoch value since it is more
+// likely to be an ISO 8601 basic format date.
+func parseEpoch(s string) (time.Time, string, bool) {
+	integer, frac, hasFrac := strings.Cut(s, ".")
+	if integer == "" || strings.Trim(integer, "0123456789") != "" {
+		return time.Time{}, "", false
+	}
+	if hasFrac && (frac == "" || strings.Trim(frac, "0123456789") != "") {
+		return time.Time{}, "", false
+	}
+	n, err := strconv.ParseInt(integer, 10, 64)
+	if err != nil {
+		return time.Time{}, "", false
+	}
+	var (
+		name string
+		unit time.Duration
+	)
+	switch len(integer) {
+	case 9, 10, 11:
+		name, unit = "unix", time.Second
+	case 12, 13, 14:
+		name, unit = "unix_millis", time.Millisecond
+	case 15, 16, 17:
+		name, unit = "unix_micros", time.Microsecond
+	case 18:
+		if hasFrac {
+			return time.Time{}, "", false
+		}
+		// FILETIME is the number of 100ns intervals since
+		// 1601-01-01T00:00:00Z.
+		const epochDelta = 116444736000000000
+		n -= epochDelta
+		return time.Unix(n/1e7, (n%1e7)*100).UTC(), "windows_filetime", true
+	case 19:
+		if hasFrac {
+			return time.Time{}, "", false
+		}
+		return time.Unix(0, n).UTC(), "unix_nanos", true
+	default:
+		return time.Time{}, "", false
+	}
+	perSec := int64(time.Second / unit)
+	var nsec int64
+	if hasFrac {
+		// Use at most enough fractional digits for
+		// nanosecond precision.
+		digits := len(strconv.FormatInt(int64(unit), 10)) - 1
+		if len(frac) > digits {
+			frac = frac[:digits]
+		}
+		f, _ := strconv.ParseInt(frac+strings.Repeat("0", digits-len(frac)), 10, 64)
+		nsec = f
+	}
+	return time.Unix(n/perSec, (n%perSec)*int64(unit)+nsec).UTC(), name, true
+}
+
+// syslogYear returns t, which has no year, in the year that places it
+// closest to but no later than one day after now. February 29 is placed
+// in the most recent such leap year.
+func syslogYear(t, now time.Time) time.Time {
+	limit := now.AddDate(0, 0, 1)
+	year := now.Year()
+	for {
+		y := time.Date(year, t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+		// The day does not survive for February 29 in
+		// a non-leap year. Leap years are at most eight
+		// years apart, so this terminates.
+		if y.Day() == t.Day() && !y.After(limit) {
+			return y
+		}
+		year--
+	}
+}
+
+// translateLayout returns a function that translates a layout string to a
+// Go time layout using fn.
+func translateLayout(fn func(string) (string, error)) functions.UnaryOp {
+	return func(arg ref.Val) ref.Val {
+		l, ok := arg.(types.String)
+		if !ok {
+			return types.ValOrErr(l, "no such overload for time layout: %s", arg.Type())
+		}
+		layout, err := fn(string(l))
+		if err != nil {
+			return types.NewErr("failed to translate layout: %v", err)
+		}
+		return types.String(layout)
+	}
+}
+
+// formatDuration returns a function that returns the string representation
+// of a duration produced by fn.
+func formatDuration(fn func(time.Duration) string) functions.UnaryOp {
+	return func(arg ref.Val) ref.Val {
+		d, ok := arg.(types.Duration)
+		if !ok {
+			return types.ValOrErr(d, "no such overload for duration format: %s", arg.Type())
+		}
+		return types.String(fn(d.Duration))
+	}
+}
+
+func parseDuration(arg ref.Val) ref.Val {
+	s, ok := arg.(types.String)
+	if !ok {
+		return types.ValOrErr(s, "no such overload for duration: %s", arg.Type())
+	}
+	d, err := parseISODuration(string(s))
+	if err != nil {
+		return types.NewErr("failed to parse duration: %v", err)
+	}
+	return types.Duration{Duration: d}
+}
+
+// durationIn returns a function that returns a duration as a number of
+// units.
+func durationIn(unit time.Duration) functions.UnaryOp {
+	return func(arg ref.Val) ref.Val {
+		d, ok := arg.(types.Duration)
+		if !ok {
+			return types.ValOrErr(d, "no such overload for duration: %s", arg.Type())
+		}
+		sec, nsec := d.Duration/unit, d.Duration%unit
+		return types.Double(float64(sec) + float64(nsec)/float64(unit))
+	}
+}
+
+// unixTime returns a function that returns the number of units since the
+// Unix epoch for a timestamp, truncated toward negative infinity.
+func unixTime(unit time.Duration) functions.UnaryOp {
+	return func(arg ref.Val) ref.Val {
+		obj, ok := arg.(types.Timestamp)
+		if !ok {
+			return types.ValOrErr(obj, "no such overload for unix time: %s", arg.Type())
+		}
+		sec := obj.Unix()
+		nsec := int64(obj.Nanosecond())
+		perSec := int64(time.Second / unit)
+		if sec > math.MaxInt64/perSec || sec < math.MinInt64/perSec {
+			return types.NewErr("unix time overflow")
+		}
+		// The fractional part is never negative, so only
+		// positive times may overflow when it is added.
+		base, frac := sec*perSec, nsec/int64(unit)
+		if base > math.MaxInt64-frac {
+			return types.NewErr("unix time overflow")
+		}
+		return types.Int(base + frac)
+	}
+}
+
+// unixTimeDouble returns a function that returns the number of units since
+// the Unix epoch for a timestamp, including the fractional part.
+func unixTimeDouble(unit time.Duration) functions.UnaryOp {
+	return func(arg ref.Val) ref.Val {
+		obj, ok := arg.(types.Timestamp)
+		if !ok {
+			return types.ValOrErr(obj, "no such overload for unix time: %s", arg.Type())
+		}
+		return types.Double(float64(obj.Unix())*float64(time.Second/unit) + float64(obj.Nanosecond())/float64(unit))
+	}
+}
+
+// Limits of the CEL timestamp range as seconds since the Unix epoch.
+const (
+	// Number of seconds between `0001-01-01T00:00:00Z` and the Unix epoch.
+	minUnixTime int64 = -62135596800
+	// Number of seconds between `9999-12-31T23:59:59.999999999Z` and the Unix epoch.
+	maxUnixTime int64 = 253402300799
+)
+
+// fromUnixTime returns a function that returns the timestamp for a number
+// of units since the Unix epoch. Times outside the range of CEL timestamps
+// are an error.
+func fromUnixTime(unit time.Duration) functions.UnaryOp {
+	perSec := int64(time.Second / unit)
+	return func(arg ref.Val) ref.Val {
+		var t time.Time
+		switch n := arg.(type) {
+		case types.Int:
+			sec := int64(n) / perSec
+			rem := int64(n) % perSec
+			if rem < 0 {
+				sec--
+				rem += perSec
+			}
+			t = time.Unix(sec, rem*int64(unit)).UTC()
+		case types.Double:
+			f := float64(n)
+			if math.IsNaN(f) || math.IsInf(f, 0) || math.Abs(f/float64(perSec)) > math.MaxInt64 {
+				return types.NewErr("unix time out of range: %v", f)
+			}
+			sec := math.Floor(f / float64(perSec))
+			nsec := math.Round((f - sec*float64(perSec)) * float64(unit))
+			t = time.Unix(int64(sec), int64(nsec)).UTC()
+		default:
+			return types.ValOrErr(n, "no such overload for unix time: %s", arg.Type())
+		}
+		if sec := t.Unix(); sec < minUnixTime || sec > maxUnixTime {
+			return types.NewErr("unix time out of range: %v", arg)
+		}
+		return types.Timestamp{Time: t}
+	}
+}
+
+// locations is a cache of loaded time zone locations.
+var locations sync.Map // map[string]*time.Location
+
+// location returns the time zone location named by name.
+func location(name ref.Val) (*time.Location, ref.Val) {
+	n, ok := name.(types.String)
+	if !ok {
+		return nil, types.ValOrErr(n, "no such overload for time location: %s", name.Type())
+	}
+	if loc, ok := locations.Load(string(n)); ok {
+		return loc.(*time.Location), nil
+	}
+	loc, err := time.LoadLocation(string(n))
+	if err != nil {
+		return nil, types.NewErr("failed to load location: %v", err)
+	}
+	locations.Store(string(n), loc)
+	return loc, nil
 }